@@ -0,0 +1,11 @@
+-- the original delivery_order table
+-- databases set up before migrations may already have any of these
+CREATE TABLE IF NOT EXISTS delivery_order (
+  id       serial PRIMARY KEY,
+  distance real   NOT NULL,
+  is_taken bool   NOT NULL DEFAULT false
+);
+
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS duration_seconds integer;
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS cancelled_at timestamptz;
@@ -0,0 +1,22 @@
+-- replaces delivery_order.is_taken with a status column
+-- for databases created before the status enum
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'delivery_order' AND column_name = 'is_taken'
+  ) THEN
+    ALTER TABLE delivery_order
+      ADD COLUMN status text NOT NULL DEFAULT 'UNASSIGN'
+      CHECK (status IN ('UNASSIGN', 'TAKEN', 'DELIVERED', 'CANCELLED'));
+
+    UPDATE delivery_order SET status = CASE
+      WHEN cancelled_at IS NOT NULL THEN 'CANCELLED'
+      WHEN is_taken THEN 'TAKEN'
+      ELSE 'UNASSIGN'
+    END;
+
+    ALTER TABLE delivery_order DROP COLUMN is_taken;
+  END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+-- records when an order was delivered
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS delivered_at timestamptz;
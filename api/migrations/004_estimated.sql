@@ -0,0 +1,2 @@
+-- flags orders whose distance is a straight-line fallback
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS estimated bool NOT NULL DEFAULT false;
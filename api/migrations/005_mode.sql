@@ -0,0 +1,2 @@
+-- the travel mode the distance was computed for
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS mode text NOT NULL DEFAULT 'driving';
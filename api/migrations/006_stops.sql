@@ -0,0 +1,2 @@
+-- number of waypoints between origin and destination
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS stops integer NOT NULL DEFAULT 0;
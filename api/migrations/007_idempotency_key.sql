@@ -0,0 +1,2 @@
+-- hashed client and Idempotency-Key of the request that placed an order
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS idempotency_key text UNIQUE;
@@ -0,0 +1,3 @@
+-- readable origin and destination, set when the order was placed with geocode=true
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS origin_address text;
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS destination_address text;
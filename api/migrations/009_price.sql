@@ -0,0 +1,3 @@
+-- fare quoted when the order was placed, in minor units of currency
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS price_cents bigint;
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS currency text;
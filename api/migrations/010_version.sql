@@ -0,0 +1,2 @@
+-- bumped on every change so clients can tell theirs is stale
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
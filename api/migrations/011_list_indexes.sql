@@ -0,0 +1,14 @@
+-- indexes for GET /orders so it doesn't scan the whole table
+--
+-- WHERE status = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3
+-- is expected to plan as an Index Scan on delivery_order_status_created_at
+-- with no Sort node, the index is read backwards
+CREATE INDEX IF NOT EXISTS delivery_order_status_created_at ON delivery_order (status, created_at, id);
+
+-- unfiltered pages, same plan on delivery_order_created_at
+CREATE INDEX IF NOT EXISTS delivery_order_created_at ON delivery_order (created_at, id);
+
+-- keyset pages, WHERE status = $1 AND id < $2 ORDER BY id DESC, are an
+-- Index Scan Backward on delivery_order_status_id. Without a status
+-- the id primary key already serves them
+CREATE INDEX IF NOT EXISTS delivery_order_status_id ON delivery_order (status, id);
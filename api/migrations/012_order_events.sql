@@ -0,0 +1,10 @@
+-- audit log of every change to an order and who made it
+CREATE TABLE IF NOT EXISTS order_events (
+  id         bigserial   PRIMARY KEY,
+  order_id   integer     NOT NULL REFERENCES delivery_order (id),
+  type       text        NOT NULL,
+  actor      text        NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id ON order_events (order_id, id);
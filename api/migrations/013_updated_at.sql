@@ -0,0 +1,15 @@
+-- when an order last changed, existing orders get their latest timestamp
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'delivery_order' AND column_name = 'updated_at'
+  ) THEN
+    ALTER TABLE delivery_order ADD COLUMN updated_at timestamptz;
+    UPDATE delivery_order SET updated_at = greatest(created_at, cancelled_at, delivered_at);
+    ALTER TABLE delivery_order
+      ALTER COLUMN updated_at SET NOT NULL,
+      ALTER COLUMN updated_at SET DEFAULT now();
+  END IF;
+END
+$$;
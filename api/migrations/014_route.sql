@@ -0,0 +1,3 @@
+-- points an order was measured along, origin first, so its
+-- destination can be changed. Older orders don't have one
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS route text[];
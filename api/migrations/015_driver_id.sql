@@ -0,0 +1,6 @@
+-- the driver who took an order, null for unassigned orders and ones
+-- taken before drivers were recorded
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS driver_id text;
+
+-- GET /driver/:id/orders, same plans as the list indexes
+CREATE INDEX IF NOT EXISTS delivery_order_driver_created_at ON delivery_order (driver_id, created_at, id);
@@ -0,0 +1,3 @@
+-- the traffic model the duration was estimated with, null when maps
+-- was left to its default
+ALTER TABLE delivery_order ADD COLUMN IF NOT EXISTS traffic_model text;
@@ -0,0 +1,6 @@
+-- keyset pages follow the newest first sort now,
+-- WHERE status = $1 AND (created_at, id) < ($2, $3)
+-- ORDER BY created_at DESC, id DESC LIMIT $4
+-- is the same Index Scan Backward on delivery_order_status_created_at
+-- as offset pages, so the id index isn't used anymore
+DROP INDEX IF EXISTS delivery_order_status_id;
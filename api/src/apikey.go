@@ -0,0 +1,36 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"net/http"
+)
+
+type apiKeyKey struct{}
+
+// APIKeys believes the X-API-Key of a request when it's one of keys
+// and stores it in the request context. Clients are then told apart
+// by it rather than their address, for rate limits, idempotency keys
+// and the audit log. Any other key is ignored, clients could
+// otherwise pick a new one for every request to get a fresh bucket
+func APIKeys(keys []string, next http.Handler) http.Handler {
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("X-API-Key")
+		if !known[key] {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx := context.WithValue(req.Context(), apiKeyKey{}, key)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// apiKeyFrom is the API key APIKeys accepted, empty when there's none
+func apiKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyKey{}).(string)
+	return key
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEvent is a change to an order as recorded in order_events
+type AuditEvent struct {
+	// one of the Event* types
+	Type      string
+	Actor     string
+	CreatedAt time.Time
+}
+
+type AuditEventResponse struct {
+	Type      string `json:"type"`
+	Actor     string `json:"actor"`
+	CreatedAt string `json:"created_at"`
+}
+
+type OrderHistoryResponse struct {
+	Events []AuditEventResponse `json:"events"`
+}
+
+// actor identifies who's making req in the audit log, API keys are
+// hashed like idempotency keys so they aren't stored in the db
+func actor(req *http.Request) string {
+	key := clientKey(req)
+	if strings.HasPrefix(key, "key:") {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return key
+}
+
+func (s *Services) orderHistoryHandler(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+) {
+	// deadline set by RequestTimeout
+	ctx := req.Context()
+
+	// assert required values
+	id, err := strconv.ParseInt(params[0].Value, 10, 64)
+	if err != nil || id <= 0 {
+		ErrorBadRequest(w, CodeInvalidParameters, errors.New("Invalid parameters"))
+		return
+	}
+
+	// get events from db, oldest first
+	events, err := s.Store.OrderHistory(ctx, id)
+	if err != nil && deadlineExceeded(ctx) {
+		ErrorGatewayTimeout(w, CodeTimeout, err)
+		return
+	}
+	if err == ErrOrderNotFound {
+		ErrorNotFound(w, CodeOrderNotFound, err)
+		return
+	}
+	if err != nil {
+		ErrorDatabase(w, CodeDatabaseError, err)
+		return
+	}
+
+	// write response
+	response := &OrderHistoryResponse{Events: make([]AuditEventResponse, len(events))}
+	for i, e := range events {
+		response.Events[i] = AuditEventResponse{
+			Type:      e.Type,
+			Actor:     e.Actor,
+			CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339),
+		}
+	}
+	blob, err := json.Marshal(response)
+	if err != nil {
+		ErrorJSONMarshal(w, CodeInternalError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(blob)
+	return
+}
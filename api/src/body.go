@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// parseBody unmarshals a JSON request body into v, with an error
+// that tells the client what's wrong with it: an empty body, broken
+// JSON and where, or a field of the wrong type
+func parseBody(blob []byte, v interface{}) error {
+	if len(bytes.TrimSpace(blob)) == 0 {
+		return errors.New("request body is empty")
+	}
+	err := json.Unmarshal(blob, v)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *json.SyntaxError:
+		return fmt.Errorf("invalid JSON at byte %d: %s", err.Offset, err)
+	case *json.UnmarshalTypeError:
+		// points unmarshal themselves so their fields aren't named
+		if err.Field == "" {
+			return fmt.Errorf("got %s where %s was expected", jsonValue(err.Value), jsonType(err.Type))
+		}
+		return fmt.Errorf("field %s must be %s", err.Field, jsonType(err.Type))
+	}
+	return err
+}
+
+// jsonValue is the kind of JSON value encoding/json calls v, with
+// its article
+func jsonValue(v string) string {
+	switch v {
+	case "array", "object":
+		return "an " + v
+	case "bool":
+		return "a boolean"
+	}
+	return "a " + v
+}
+
+// jsonType is how t looks in JSON, for error messages
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		body string
+		into interface{}
+		want string
+	}{
+		{`{"status": "taken"}`, &Status{}, ""},
+		{"", &Status{}, "request body is empty"},
+		{" \n\t", &Status{}, "request body is empty"},
+		{`{"status": "taken",}`, &Status{}, "invalid JSON at byte 20: invalid character '}' looking for beginning of object key string"},
+		{`{"status": "taken"`, &Status{}, "invalid JSON at byte 18: unexpected end of JSON input"},
+		{`{"status": 1}`, &Status{}, "field status must be a string"},
+		{`{"version": "2"}`, &Status{}, "field version must be an integer"},
+		{`{"version": 1.5}`, &Status{}, "field version must be an integer"},
+		{`{"waypoints": {}}`, &Location{}, "field waypoints must be an array"},
+		{`{"avoid": "tolls"}`, &Location{}, "field avoid must be an array"},
+		{`[]`, &Status{}, "got an array where an object was expected"},
+		// points unmarshal themselves so their field isn't named
+		{`{"origin": 5}`, &Location{}, "got a number where an object was expected"},
+		{`{"origin": true}`, &Location{}, "got a boolean where an object was expected"},
+	}
+	for _, test := range tests {
+		err := parseBody([]byte(test.body), test.into)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"googlemaps.github.io/maps"
+
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cached coordinates are rounded to 4 decimal places (~11m)
+// so nearby requests share an entry
+const distanceCachePrecision = 4
+
+// ttlCache is an LRU whose entries expire after ttl,
+// safe for concurrent use
+type ttlCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  *list.List
+	items    map[string]*list.Element
+}
+
+type ttlCacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(capacity int, ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*ttlCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.entries.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.entries.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *ttlCache) add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*ttlCacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.entries.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.entries.PushFront(&ttlCacheEntry{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+	// evict the least recently used entry
+	if c.entries.Len() > c.capacity {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.items, oldest.Value.(*ttlCacheEntry).key)
+	}
+}
+
+// DistanceCache is an LRU of distance matrix responses whose
+// entries expire after ttl, safe for concurrent use
+type DistanceCache struct {
+	cache *ttlCache
+}
+
+func NewDistanceCache(capacity int, ttl time.Duration) *DistanceCache {
+	return &DistanceCache{cache: newTTLCache(capacity, ttl)}
+}
+
+func (c *DistanceCache) Get(key string) (*maps.DistanceMatrixResponse, bool) {
+	v, ok := c.cache.get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*maps.DistanceMatrixResponse), true
+}
+
+func (c *DistanceCache) Add(key string, resp *maps.DistanceMatrixResponse) {
+	c.cache.add(key, resp)
+}
+
+// GeocodeCache is an LRU of addresses keyed by rounded
+// coordinates, entries expire after ttl
+type GeocodeCache struct {
+	cache *ttlCache
+}
+
+func NewGeocodeCache(capacity int, ttl time.Duration) *GeocodeCache {
+	return &GeocodeCache{cache: newTTLCache(capacity, ttl)}
+}
+
+func (c *GeocodeCache) Get(key string) (string, bool) {
+	v, ok := c.cache.get(key)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+func (c *GeocodeCache) Add(key string, address string) {
+	c.cache.add(key, address)
+}
+
+// StatsCache keeps order stats by window for a short while so
+// dashboards refreshing together don't each query the db
+type StatsCache struct {
+	cache *ttlCache
+}
+
+func NewStatsCache(capacity int, ttl time.Duration) *StatsCache {
+	return &StatsCache{cache: newTTLCache(capacity, ttl)}
+}
+
+func (c *StatsCache) Get(window time.Duration) (OrderStats, bool) {
+	v, ok := c.cache.get(window.String())
+	if !ok {
+		return OrderStats{}, false
+	}
+	return v.(OrderStats), true
+}
+
+func (c *StatsCache) Add(window time.Duration, stats OrderStats) {
+	c.cache.add(window.String(), stats)
+}
+
+// distanceCacheKey normalizes the places, mode, language and route
+// options of a request
+func distanceCacheKey(r *maps.DistanceMatrixRequest) string {
+	origins := make([]string, len(r.Origins))
+	for i, place := range r.Origins {
+		origins[i] = normalizePlace(place)
+	}
+	destinations := make([]string, len(r.Destinations))
+	for i, place := range r.Destinations {
+		destinations[i] = normalizePlace(place)
+	}
+	return strings.Join(origins, "|") + ">" + strings.Join(destinations, "|") + "@" + string(r.Mode) + "/" + r.Language + "/" + string(r.Avoid) + "/" + string(r.TrafficModel)
+}
+
+// normalizePlace rounds "lat,lng" coordinates and
+// lowercases addresses
+func normalizePlace(place string) string {
+	parts := strings.Split(place, ",")
+	if len(parts) == 2 {
+		lat, latErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lng, lngErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if latErr == nil && lngErr == nil {
+			return strconv.FormatFloat(lat, 'f', distanceCachePrecision, 64) +
+				"," + strconv.FormatFloat(lng, 'f', distanceCachePrecision, 64)
+		}
+	}
+	return strings.ToLower(strings.Join(strings.Fields(place), " "))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP sets req.RemoteAddr to the client's address when the
+// request came through one of the trusted proxies, taken from
+// X-Forwarded-For or else X-Real-IP. Those headers are ignored from
+// anyone else since clients can send whatever they like in them
+func ClientIP(trusted []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, port, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil || !isTrusted(trusted, host) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// each proxy appends who it got the request from, the client
+		// is the last address that isn't one of ours
+		client := ""
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break
+				}
+				client = hop
+				if !isTrusted(trusted, hop) {
+					break
+				}
+			}
+		} else if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			client = ip
+		}
+		if client != "" {
+			req.RemoteAddr = net.JoinHostPort(client, port)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func isTrusted(trusted []*net.IPNet, host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs reads a comma separated list of CIDRs, a plain IP is
+// taken as just that address
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.8", true},
+		{"*", true},
+		{"", false},
+		{"deflate, br", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"gzip;q=0.001", true},
+	}
+	for _, test := range tests {
+		if got := acceptsGzip(test.header); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.header, got, test.want)
+		}
+	}
+}
+
+// bodies gzipped on the way out read back the same
+func TestCompress(t *testing.T) {
+	big := `{"orders": [` + strings.Repeat(`{"id": 1, "distance": 1000, "status": "UNASSIGN"}, `, 40) + `{}]}`
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+		wantGzip       bool
+	}{
+		{"big", "gzip", big, true},
+		{"at the minimum", "gzip", big[:100], true},
+		{"under the minimum", "gzip", big[:99], false},
+		{"not accepted", "", big, false},
+		{"refused", "gzip;q=0", big, false},
+	}
+	for _, test := range tests {
+		handler := Compress(100, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(201)
+			// in pieces, like an encoder would
+			for i := 0; i < len(test.body); i += 30 {
+				end := i + 30
+				if end > len(test.body) {
+					end = len(test.body)
+				}
+				w.Write([]byte(test.body[i:end]))
+			}
+		}))
+		req := httptest.NewRequest("GET", "/orders", nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.wantGzip || w.Code != 201 || w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: got %d gzipped %t with headers %v, want 201 gzipped %t", test.name, w.Code, gzipped, w.Header(), test.wantGzip)
+			continue
+		}
+		got := w.Body.Bytes()
+		if gzipped {
+			r, err := gzip.NewReader(w.Body)
+			if err == nil {
+				got, err = ioutil.ReadAll(r)
+			}
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+				continue
+			}
+		}
+		if string(got) != test.body {
+			t.Errorf("%s: got body %q, want %q", test.name, got, test.body)
+		}
+	}
+}
+
+// a stream flushing before it reaches the minimum goes out as it is
+func TestCompressFlush(t *testing.T) {
+	handler := Compress(100, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("event: taken\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("x", 200)))
+	}))
+	req := httptest.NewRequest("GET", "/order/1/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || !w.Flushed || w.Body.String() != "event: taken\n\n"+strings.Repeat("x", 200) {
+		t.Errorf("got headers %v flushed %t body %q, want it uncompressed", w.Header(), w.Flushed, w.Body)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// unlimitedPaths are never turned away by ConcurrencyLimit, probes
+// and scrapes have to get through most when the api is busy
+var unlimitedPaths = map[string]bool{
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// ConcurrencyLimit serves at most max requests at once across all
+// clients and answers the rest with a 503 straight away, so a
+// stampede can't pile up on maps and the db pool. The per client
+// RateLimiter is separate
+func ConcurrencyLimit(max int, next http.Handler) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if unlimitedPaths[req.URL.Path] {
+			next.ServeHTTP(w, req)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, req)
+		default:
+			w.Header().Set("Retry-After", "1")
+			ErrorServiceUnavailable(w, CodeServerBusy, errors.New("Too many requests in flight"))
+		}
+	})
+}
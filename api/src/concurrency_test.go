@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	const max = 3
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(max, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/orders" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// fill every slot
+	var wg sync.WaitGroup
+	codes := make(chan int, max)
+	for i := 0; i < max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- get("/orders").Code
+		}()
+	}
+	for i := 0; i < max; i++ {
+		<-started
+	}
+
+	w := get("/orders")
+	if w.Code != 503 || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), CodeServerBusy) {
+		t.Errorf("request %d: got %d %s Retry-After %q, want a 503 %s", max+1, w.Code, w.Body, w.Header().Get("Retry-After"), CodeServerBusy)
+	}
+	for _, path := range []string{"/ready", "/metrics"} {
+		if w := get(path); w.Code != 200 {
+			t.Errorf("%s while busy: got %d, want it let through", path, w.Code)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != 200 {
+			t.Errorf("got %d for a request in a slot, want 200", code)
+		}
+	}
+	// slots are given back
+	go func() { <-started }()
+	if w := get("/orders"); w.Code != 200 {
+		t.Errorf("after the requests finished: got %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything the api reads from the environment
+type Config struct {
+	LogLevel int
+
+	DBURI          string
+	DBMaxRetries   int
+	DBRetryTimeout time.Duration
+	// startup gives up connecting to the db after DBConnectDeadline
+	// however many retries are left
+	DBConnectDeadline time.Duration
+	// pgx v3's ConnPool only takes a maximum, it has no minimum size,
+	// connection lifetime or idle time to configure
+	DBMaxConnections int
+	// how long a query waits for a free connection, 0 waits forever
+	DBAcquireTimeout time.Duration
+	// store calls slower than this are logged, 0 logs none
+	SlowQuery     time.Duration
+	MigrationsDir string
+
+	// MapsProvider is google or haversine, which needs no API key
+	MapsProvider     string
+	MapsAPIKey       string
+	MapsMaxAttempts  int
+	MapsRetryBackoff time.Duration
+	FallbackEnabled  bool
+	// how long a maps call counts for deep readiness checks before
+	// they probe maps themselves
+	MapsHealthInterval time.Duration
+
+	// language of maps results and region geocoding is biased to,
+	// empty leaves it to maps. Requests can ask for others
+	MapsLanguage string
+	MapsRegion   string
+
+	// used when a request doesn't ask for a mode or units
+	DefaultTravelMode string
+	DefaultUnits      string
+	// route options used when a request doesn't set them
+	DefaultAvoid        []string
+	DefaultTrafficModel string
+
+	// orders routed longer than this, in meters, are rejected, 0
+	// accepts any distance
+	MaxOrderDistance int
+
+	// unassigned orders are cancelled after OrderTTL, 0 keeps them
+	OrderTTL            time.Duration
+	OrderExpiryInterval time.Duration
+
+	// fares are PriceBaseFare plus PricePerKm for every km and
+	// PricePerMinute for every minute, at least PriceMinimumFare
+	PriceBaseFare    float64
+	PricePerKm       float64
+	PricePerMinute   float64
+	PriceMinimumFare float64
+	PriceCurrency    string
+
+	// taken orders are POSTed to WebhookURL when it's set,
+	// signed with WebhookSecret
+	WebhookURL     string
+	WebhookSecret  string
+	WebhookTimeout time.Duration
+
+	// how long an Idempotency-Key keeps returning the same order
+	IdempotencyKeyTTL time.Duration
+
+	// DistanceCacheSize of 0 turns the cache off
+	DistanceCacheSize int
+	DistanceCacheTTL  time.Duration
+	// GeocodeCacheSize of 0 turns the cache off
+	GeocodeCacheSize int
+	GeocodeCacheTTL  time.Duration
+	// StatsCacheTTL of 0 turns the cache off
+	StatsCacheTTL time.Duration
+
+	ListenAddr  string
+	MetricsAddr string
+	// https is served when both are set, plain http otherwise
+	TLSCertFile string
+	TLSKeyFile  string
+	// browsers on other origins are denied when this is empty
+	CORSOrigins []string
+	// seconds browsers cache preflights for, 0 makes them preflight
+	// every request
+	CORSMaxAge int
+	// proxies whose X-Forwarded-For is believed, none when empty
+	TrustedProxies []*net.IPNet
+	// X-API-Key values clients are told apart by, other keys are
+	// ignored and clients go by their address
+	APIKeys []string
+
+	// RateLimitRPS of 0 turns rate limiting off
+	RateLimitRPS   float64
+	RateLimitBurst int
+	RateLimitIdle  time.Duration
+	// requests served at once, 0 for no limit
+	MaxConcurrentRequests int
+
+	RequestTimeout  time.Duration
+	ReadyTimeout    time.Duration
+	ShutdownTimeout time.Duration
+	MaxBodyBytes    int64
+	// responses this big are gzipped, 0 turns compression off
+	CompressMinBytes int
+	// indent every JSON response, requests can ask with ?pretty=1
+	PrettyJSON bool
+}
+
+// configLoader reads env vars into a Config and keeps every
+// problem it finds so they can all be reported at once
+type configLoader struct {
+	problems []string
+}
+
+func (l *configLoader) invalid(name, value string) {
+	l.problems = append(l.problems, "invalid "+name+" "+strconv.Quote(value))
+}
+
+func (l *configLoader) required(name string) string {
+	v := os.Getenv(name)
+	if v == "" {
+		l.problems = append(l.problems, name+" is not set")
+	}
+	return v
+}
+
+func (l *configLoader) int(name string, def, min int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		l.invalid(name, v)
+		return def
+	}
+	return n
+}
+
+func (l *configLoader) int64(name string, def, min int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min {
+		l.invalid(name, v)
+		return def
+	}
+	return n
+}
+
+func (l *configLoader) float(name string, def, min float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < min {
+		l.invalid(name, v)
+		return def
+	}
+	return f
+}
+
+func (l *configLoader) bool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.invalid(name, v)
+		return def
+	}
+	return b
+}
+
+func (l *configLoader) duration(name string, def, min time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < min {
+		l.invalid(name, v)
+		return def
+	}
+	return d
+}
+
+// loadConfig reads the environment, falling back to defaults for
+// anything optional, and fails listing every missing or bad value
+func loadConfig() (*Config, error) {
+	l := &configLoader{}
+	c := &Config{
+		LogLevel: LevelInfo,
+
+		RateLimitIdle:   10 * time.Minute,
+		ReadyTimeout:    2 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+	}
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, err := ParseLevel(v)
+		if err != nil {
+			l.invalid("LOG_LEVEL", v)
+		} else {
+			c.LogLevel = level
+		}
+	}
+
+	c.DBURI = l.required("DB_URI")
+	c.DBMaxRetries = l.int("DB_MAX_RETRIES", 10, 0)
+	c.DBRetryTimeout = time.Duration(l.int("DB_RETRY_TIMEOUT_SECONDS", 5, 0)) * time.Second
+	c.DBConnectDeadline = l.duration("DB_CONNECT_DEADLINE", 2*time.Minute, time.Second)
+	// pgx needs at least 2
+	c.DBMaxConnections = l.int("DB_MAX_CONNECTIONS", 10, 2)
+	c.DBAcquireTimeout = l.duration("DB_ACQUIRE_TIMEOUT", 5*time.Second, 0)
+	c.SlowQuery = time.Duration(l.int("SLOW_QUERY_MS", 500, 0)) * time.Millisecond
+	c.MigrationsDir = os.Getenv("MIGRATIONS_DIR")
+	if c.MigrationsDir == "" {
+		c.MigrationsDir = "migrations"
+	}
+
+	c.MapsProvider = strings.ToLower(os.Getenv("MAPS_PROVIDER"))
+	switch c.MapsProvider {
+	case "":
+		c.MapsProvider = MapsProviderGoogle
+		fallthrough
+	case MapsProviderGoogle:
+		c.MapsAPIKey = l.required("MAPS_API_KEY")
+	case MapsProviderHaversine:
+	default:
+		l.invalid("MAPS_PROVIDER", c.MapsProvider)
+	}
+	c.MapsMaxAttempts = l.int("MAPS_MAX_ATTEMPTS", 3, 1)
+	c.MapsRetryBackoff = l.duration("MAPS_RETRY_BACKOFF", 200*time.Millisecond, 0)
+	c.FallbackEnabled = l.bool("FALLBACK_ENABLED", false)
+	c.MapsHealthInterval = l.duration("MAPS_HEALTH_INTERVAL", time.Minute, time.Second)
+	c.MapsLanguage = os.Getenv("MAPS_LANGUAGE")
+	if c.MapsLanguage != "" && !languagePattern.MatchString(c.MapsLanguage) {
+		l.invalid("MAPS_LANGUAGE", c.MapsLanguage)
+	}
+	c.MapsRegion = os.Getenv("MAPS_REGION")
+	if c.MapsRegion != "" && !regionPattern.MatchString(c.MapsRegion) {
+		l.invalid("MAPS_REGION", c.MapsRegion)
+	}
+
+	c.DefaultTravelMode = strings.ToLower(os.Getenv("DEFAULT_TRAVEL_MODE"))
+	if _, ok := travelModes[c.DefaultTravelMode]; !ok && c.DefaultTravelMode != "" {
+		l.invalid("DEFAULT_TRAVEL_MODE", c.DefaultTravelMode)
+	}
+	if v := os.Getenv("DEFAULT_AVOID"); v != "" {
+		c.DefaultAvoid = strings.Split(strings.ToLower(v), ",")
+		for _, feature := range c.DefaultAvoid {
+			if _, ok := avoidable[feature]; !ok {
+				l.invalid("DEFAULT_AVOID", v)
+				break
+			}
+		}
+	}
+	c.DefaultTrafficModel = strings.ToLower(os.Getenv("DEFAULT_TRAFFIC_MODEL"))
+	if _, ok := trafficModels[c.DefaultTrafficModel]; !ok && c.DefaultTrafficModel != "" {
+		l.invalid("DEFAULT_TRAFFIC_MODEL", c.DefaultTrafficModel)
+	}
+	c.DefaultUnits = strings.ToLower(os.Getenv("DEFAULT_UNITS"))
+	switch c.DefaultUnits {
+	case "":
+		c.DefaultUnits = UnitsMetric
+	case UnitsMetric, UnitsImperial:
+	default:
+		l.invalid("DEFAULT_UNITS", c.DefaultUnits)
+	}
+
+	c.MaxOrderDistance = l.int("MAX_ORDER_DISTANCE_METERS", 0, 0)
+
+	c.OrderTTL = l.duration("ORDER_TTL", 0, 0)
+	c.OrderExpiryInterval = l.duration("ORDER_EXPIRY_INTERVAL", time.Minute, time.Second)
+
+	c.PriceBaseFare = l.float("PRICE_BASE_FARE", 2, 0)
+	c.PricePerKm = l.float("PRICE_PER_KM", 1, 0)
+	c.PricePerMinute = l.float("PRICE_PER_MINUTE", 0, 0)
+	c.PriceMinimumFare = l.float("PRICE_MINIMUM_FARE", 0, 0)
+	c.PriceCurrency = os.Getenv("PRICE_CURRENCY")
+	if c.PriceCurrency == "" {
+		c.PriceCurrency = "USD"
+	}
+
+	c.WebhookURL = os.Getenv("WEBHOOK_URL")
+	if c.WebhookURL != "" {
+		c.WebhookSecret = l.required("WEBHOOK_SECRET")
+	}
+	c.WebhookTimeout = l.duration("WEBHOOK_TIMEOUT", 5*time.Second, time.Millisecond)
+
+	c.IdempotencyKeyTTL = l.duration("IDEMPOTENCY_KEY_TTL", 24*time.Hour, time.Second)
+
+	c.DistanceCacheSize = l.int("DISTANCE_CACHE_SIZE", 1000, 0)
+	c.DistanceCacheTTL = l.duration("DISTANCE_CACHE_TTL", time.Hour, time.Nanosecond)
+	c.GeocodeCacheSize = l.int("GEOCODE_CACHE_SIZE", 1000, 0)
+	c.GeocodeCacheTTL = l.duration("GEOCODE_CACHE_TTL", 24*time.Hour, time.Nanosecond)
+	c.StatsCacheTTL = l.duration("STATS_CACHE_TTL", 10*time.Second, 0)
+
+	// LISTEN_ADDR wins over PORT, which some platforms inject
+	c.ListenAddr = os.Getenv("LISTEN_ADDR")
+	if c.ListenAddr == "" && os.Getenv("PORT") != "" {
+		c.ListenAddr = ":" + os.Getenv("PORT")
+	}
+	if c.ListenAddr == "" {
+		c.ListenAddr = ":8080"
+	}
+	_, port, err := net.SplitHostPort(c.ListenAddr)
+	if err == nil {
+		_, err = strconv.ParseUint(port, 10, 16)
+	}
+	if err != nil {
+		l.invalid("listen address", c.ListenAddr)
+	}
+	c.MetricsAddr = os.Getenv("METRICS_ADDR")
+	c.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	c.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if c.TLSCertFile != "" && c.TLSKeyFile == "" {
+		l.problems = append(l.problems, "TLS_KEY_FILE is not set but TLS_CERT_FILE is")
+	}
+	if c.TLSKeyFile != "" && c.TLSCertFile == "" {
+		l.problems = append(l.problems, "TLS_CERT_FILE is not set but TLS_KEY_FILE is")
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		c.CORSOrigins = strings.Split(v, ",")
+	}
+	c.CORSMaxAge = l.int("CORS_MAX_AGE_SECONDS", 600, 0)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		c.TrustedProxies, err = parseCIDRs(v)
+		if err != nil {
+			l.invalid("TRUSTED_PROXIES", v)
+		}
+	}
+
+	if v := os.Getenv("API_KEYS"); v != "" {
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				c.APIKeys = append(c.APIKeys, key)
+			}
+		}
+	}
+
+	c.RateLimitRPS = l.float("RATE_LIMIT_RPS", 0, 0)
+	c.RateLimitBurst = l.int("RATE_LIMIT_BURST", 20, 1)
+	c.MaxConcurrentRequests = l.int("MAX_CONCURRENT_REQUESTS", 0, 0)
+
+	c.RequestTimeout = l.duration("REQUEST_TIMEOUT", 10*time.Second, time.Nanosecond)
+	c.MaxBodyBytes = l.int64("MAX_BODY_BYTES", 1<<20, 1)
+	c.CompressMinBytes = l.int("COMPRESS_MIN_BYTES", 1024, 0)
+	c.PrettyJSON = l.bool("PRETTY_JSON", false)
+
+	if len(l.problems) > 0 {
+		return nil, errors.New(strings.Join(l.problems, "; "))
+	}
+	return c, nil
+}
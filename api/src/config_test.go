@@ -0,0 +1,148 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv sets the env vars in vars, empty ones are unset, and
+// returns a func putting them back as they were
+func setEnv(vars map[string]string) func() {
+	old := make(map[string]*string, len(vars))
+	for k, v := range vars {
+		if prev, ok := os.LookupEnv(k); ok {
+			old[k] = &prev
+		} else {
+			old[k] = nil
+		}
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+	return func() {
+		for k, v := range old {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestLoadConfigMapsAPIKey(t *testing.T) {
+	tests := []struct {
+		provider string
+		key      string
+		wantErr  bool
+	}{
+		{"", "", true},
+		{"google", "", true},
+		{"", "secret", false},
+		{"haversine", "", false},
+	}
+	for _, test := range tests {
+		restore := setEnv(map[string]string{
+			"DB_URI":        "postgres://localhost/orders",
+			"MAPS_PROVIDER": test.provider,
+			"MAPS_API_KEY":  test.key,
+		})
+		cfg, err := loadConfig()
+		restore()
+		if test.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "MAPS_API_KEY is not set") {
+				t.Errorf("provider %q without a key: got error %v, want MAPS_API_KEY is not set", test.provider, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("provider %q key %q: %s", test.provider, test.key, err)
+			continue
+		}
+		if cfg.MapsAPIKey != test.key {
+			t.Errorf("provider %q: got key %q, want %q", test.provider, cfg.MapsAPIKey, test.key)
+		}
+	}
+}
+
+// main exits before it connects to anything when the key is missing
+func TestMainExitsWithoutMapsAPIKey(t *testing.T) {
+	if os.Getenv("TEST_MAIN") == "1" {
+		main()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=TestMainExitsWithoutMapsAPIKey")
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "MAPS_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, "TEST_MAIN=1", "DB_URI=postgres://localhost/orders")
+	out, err := cmd.CombinedOutput()
+	if err == nil || err.Error() != "exit status 2" {
+		t.Fatalf("got %v, want main to exit with status 2", err)
+	}
+	if !strings.Contains(string(out), "MAPS_API_KEY is not set") {
+		t.Errorf("got output %q, want it to say MAPS_API_KEY is not set", out)
+	}
+}
+
+func TestLoadConfigPool(t *testing.T) {
+	tests := []struct {
+		maxConnections string
+		acquireTimeout string
+		wantMax        int
+		wantTimeout    time.Duration
+		wantErr        string
+	}{
+		{"", "", 10, 5 * time.Second, ""},
+		{"50", "250ms", 50, 250 * time.Millisecond, ""},
+		{"2", "0s", 2, 0, ""},
+		// pgx needs at least 2
+		{"1", "", 0, 0, "DB_MAX_CONNECTIONS"},
+		{"many", "", 0, 0, "DB_MAX_CONNECTIONS"},
+		{"", "5", 0, 0, "DB_ACQUIRE_TIMEOUT"},
+		{"", "-1s", 0, 0, "DB_ACQUIRE_TIMEOUT"},
+	}
+	for _, test := range tests {
+		restore := setEnv(map[string]string{
+			"DB_URI":             "postgres://localhost/orders",
+			"MAPS_API_KEY":       "secret",
+			"DB_MAX_CONNECTIONS": test.maxConnections,
+			"DB_ACQUIRE_TIMEOUT": test.acquireTimeout,
+		})
+		cfg, err := loadConfig()
+		restore()
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%q %q: got error %v, want one about %s", test.maxConnections, test.acquireTimeout, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q %q: %s", test.maxConnections, test.acquireTimeout, err)
+			continue
+		}
+		if cfg.DBMaxConnections != test.wantMax || cfg.DBAcquireTimeout != test.wantTimeout {
+			t.Errorf("%q %q: got %d connections waiting %s, want %d waiting %s", test.maxConnections, test.acquireTimeout,
+				cfg.DBMaxConnections, cfg.DBAcquireTimeout, test.wantMax, test.wantTimeout)
+		}
+	}
+}
+
+// a busy pool is worth retrying like a lost connection
+func TestErrorDatabaseAcquireTimeout(t *testing.T) {
+	w := httptest.NewRecorder()
+	ErrorDatabase(w, CodeDatabaseError, pgx.ErrAcquireTimeout)
+	if w.Code != 503 || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), CodeDatabaseUnavailable) {
+		t.Errorf("got %d %s Retry-After %q, want a 503 %s", w.Code, w.Body, w.Header().Get("Retry-After"), CodeDatabaseUnavailable)
+	}
+}
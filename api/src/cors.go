@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// methods and headers browsers are allowed to use cross-origin
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Content-Type, X-API-Key, X-Request-ID, Idempotency-Key, If-None-Match, If-Match, X-API-Version"
+	corsExposeHeaders = "X-Request-ID, Retry-After, Link, ETag"
+)
+
+// CORS lets browsers on the allowed origins call the api
+// with no origins configured every cross-origin request is denied
+type CORS struct {
+	origins map[string]bool
+	any     bool
+	// Access-Control-Max-Age of preflights, in seconds
+	maxAge string
+}
+
+// NewCORS takes the allowed origins, "*" allows any, and how many
+// seconds browsers can cache a preflight for, 0 for not at all
+func NewCORS(origins []string, maxAge int) *CORS {
+	c := &CORS{origins: make(map[string]bool), maxAge: strconv.Itoa(maxAge)}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			c.any = true
+		} else if origin != "" {
+			c.origins[origin] = true
+		}
+	}
+	return c
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.any || c.origins[origin]
+}
+
+// Middleware answers preflights itself, before rate limiting, and
+// adds the allow headers to actual requests from allowed origins
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// responses differ per origin so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			if preflight {
+				// no allow headers, the browser blocks the request
+				w.WriteHeader(204)
+				return
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(204)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMaxAge(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxAge     int
+		method     string
+		origin     string
+		wantMaxAge string
+	}{
+		{"preflight", 600, "OPTIONS", "https://app.example.com", "600"},
+		{"preflight not cached", 0, "OPTIONS", "https://app.example.com", "0"},
+		{"preflight from another origin", 600, "OPTIONS", "https://evil.example.com", ""},
+		// only preflights are cached
+		{"actual request", 600, "GET", "https://app.example.com", ""},
+	}
+	for _, test := range tests {
+		handled := false
+		cors := NewCORS([]string{"https://app.example.com"}, test.maxAge)
+		handler := cors.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handled = true
+		}))
+		req := httptest.NewRequest(test.method, "/orders", nil)
+		req.Header.Set("Origin", test.origin)
+		if test.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get("Access-Control-Max-Age"); got != test.wantMaxAge {
+			t.Errorf("%s: got Max-Age %q, want %q", test.name, got, test.wantMaxAge)
+		}
+		if test.method == "OPTIONS" && (w.Code != 204 || handled) {
+			t.Errorf("%s: got %d handled %t, want a 204 from the middleware", test.name, w.Code, handled)
+		}
+	}
+}
+
+func TestLoadConfigCORSMaxAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 600, false},
+		{"0", 0, false},
+		{"86400", 86400, false},
+		{"-1", 0, true},
+		{"10m", 0, true},
+	}
+	for _, test := range tests {
+		restore := setEnv(map[string]string{
+			"DB_URI":               "postgres://localhost/orders",
+			"MAPS_API_KEY":         "secret",
+			"CORS_MAX_AGE_SECONDS": test.value,
+		})
+		cfg, err := loadConfig()
+		restore()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want one %t", test.value, err, test.wantErr)
+			continue
+		}
+		if err == nil && cfg.CORSMaxAge != test.want {
+			t.Errorf("%q: got %d, want %d", test.value, cfg.CORSMaxAge, test.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+
+	"net"
+	"time"
+)
+
+// connectDB opens the pool, retrying up to cfg.DBMaxRetries times
+// while the db comes up, and gives up at cfg.DBConnectDeadline
+func connectDB(config pgx.ConnConfig, cfg *Config) (*pgx.ConnPool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DBConnectDeadline)
+	defer cancel()
+
+	// a dial that hangs can't outlast the deadline either, the
+	// keepalive is the pgx default
+	config.Dial = (&net.Dialer{Timeout: cfg.DBConnectDeadline, KeepAlive: 5 * time.Minute}).Dial
+	poolConfig := pgx.ConnPoolConfig{
+		ConnConfig:     config,
+		MaxConnections: cfg.DBMaxConnections,
+		AcquireTimeout: cfg.DBAcquireTimeout,
+	}
+	logger.Info("Connecting to DB", Fields{
+		"max_connections": cfg.DBMaxConnections,
+		"acquire_timeout": cfg.DBAcquireTimeout.String(),
+	})
+
+	for attempt := 1; ; attempt++ {
+		pool, err := pgx.NewConnPool(poolConfig)
+		if err == nil {
+			return pool, nil
+		}
+		if attempt > cfg.DBMaxRetries {
+			return nil, err
+		}
+
+		// retry
+		logger.Warn("Error in connecting to db, retrying", Fields{
+			"error":            err,
+			"attempt":          attempt,
+			"max_attempts":     cfg.DBMaxRetries + 1,
+			"retry_in_seconds": cfg.DBRetryTimeout.Seconds(),
+		})
+		select {
+		case <-time.After(cfg.DBRetryTimeout):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DestinationChange is the body of PATCH /order/:id
+type DestinationChange struct {
+	Destination Point `json:"destination"`
+}
+
+// pointFromRoute is the Point of a stored route point, coordinates
+// when it's a valid "lat,lng" pair and an address otherwise
+func pointFromRoute(place string) Point {
+	parts := strings.Split(place, ",")
+	if len(parts) == 2 && validateCoordinates("", parts) == nil {
+		return Point{Coordinates: parts}
+	}
+	return Point{Address: place}
+}
+
+// locationFromRoute is the Location an order was measured along
+func locationFromRoute(route []string, mode string) Location {
+	loc := Location{
+		Origin:      pointFromRoute(route[0]),
+		Destination: pointFromRoute(route[len(route)-1]),
+		Mode:        mode,
+	}
+	for _, place := range route[1 : len(route)-1] {
+		loc.Waypoints = append(loc.Waypoints, pointFromRoute(place))
+	}
+	return loc
+}
+
+// changeDestinationHandler sends an order that hasn't been taken yet
+// somewhere else, its distance, duration and price are measured again
+func (s *Services) changeDestinationHandler(
+	w http.ResponseWriter,
+	req *http.Request,
+	params httprouter.Params,
+) {
+	// deadline set by RequestTimeout
+	ctx := req.Context()
+
+	// assert request header
+	if !requireJSON(w, req) {
+		return
+	}
+
+	// response units
+	units, err := unitsFromRequest(req, s.Config.DefaultUnits)
+	if err != nil {
+		ErrorInvalidParameters(w, CodeInvalidParameters, err)
+		return
+	}
+
+	// maps language and region
+	language, region, err := localeFromRequest(req, s.Config)
+	if err != nil {
+		ErrorInvalidParameters(w, CodeInvalidParameters, err)
+		return
+	}
+
+	// only change the order as seen, any version when absent
+	version, err := versionFromRequest(req)
+	if err != nil {
+		ErrorInvalidParameters(w, CodeInvalidParameters, err)
+		return
+	}
+
+	// read []byte
+	bodyBlob, err := ioutil.ReadAll(req.Body)
+	if bodyTooLarge(err) {
+		ErrorRequestEntityTooLarge(w, CodeBodyTooLarge, err)
+		return
+	}
+	if err != nil {
+		ErrorBadRequest(w, CodeMalformedRequest, err)
+		return
+	}
+
+	// convert []byte to struct
+	var change DestinationChange
+	err = parseBody(bodyBlob, &change)
+	if err != nil {
+		ErrorInvalidParameters(w, CodeMalformedRequest, err)
+		return
+	}
+
+	// assert required values
+	id, err := strconv.ParseInt(params[0].Value, 10, 64)
+	if err != nil || id <= 0 {
+		ErrorBadRequest(w, CodeInvalidParameters, errors.New("Invalid parameters"))
+		return
+	}
+	invalid := unknownFields(bodyBlob, "destination")
+	invalid = append(invalid, change.Destination.validate("destination")...)
+	if len(invalid) > 0 {
+		ErrorValidation(w, invalid)
+		return
+	}
+
+	// get the order, the new route keeps its origin and stops
+	order, err := s.Store.GetOrder(ctx, id)
+	if err != nil && deadlineExceeded(ctx) {
+		ErrorGatewayTimeout(w, CodeTimeout, err)
+		return
+	}
+	if err == ErrOrderNotFound {
+		ErrorNotFound(w, CodeOrderNotFound, err)
+		return
+	}
+	if err != nil {
+		ErrorDatabase(w, CodeDatabaseError, err)
+		return
+	}
+	if order.Status != StatusUnassign {
+		stateConflict(w, order.Status)
+		return
+	}
+	if len(order.Route) < 2 {
+		writeError(w, 422, CodeRouteUnknown, "Order was placed before routes were kept, its destination can't be changed", nil)
+		return
+	}
+	loc := locationFromRoute(order.Route, order.Mode)
+	loc.Destination = change.Destination
+	// durations stay comparable, avoided features weren't kept
+	if order.Traffic_model != nil {
+		loc.TrafficModel = *order.Traffic_model
+	}
+	err = loc.validate()
+	if err != nil {
+		ErrorValidation(w, err.(ValidationErrors))
+		return
+	}
+	loc.normalize(s.Config)
+	loc.Language, loc.Region = language, region
+
+	// get distance
+	route, err := s.measureRoute(ctx, &loc)
+	if err != nil && deadlineExceeded(ctx) {
+		ErrorGatewayTimeout(w, CodeTimeout, err)
+		return
+	}
+	if routeErr, ok := err.(*RouteError); ok {
+		writeError(w, routeErr.Status, routeErr.Code, http.StatusText(routeErr.Status), routeErr.Err)
+		return
+	}
+	if s.tooFar(route) {
+		ErrorDistanceTooFar(w, "Order is farther than the maximum distance", route.Distance, s.Config.MaxOrderDistance)
+		return
+	}
+
+	// update the order, it has to still be unassigned
+	newOrder := route.newOrder(&loc)
+	newOrder.Actor = actor(req)
+	s.quote(&newOrder)
+	// orders placed with geocode=true keep a readable destination
+	if order.Destination_address != nil {
+		destination := s.address(ctx, &loc, &loc.Destination)
+		newOrder.DestinationAddress = &destination
+	}
+	o, err := s.Store.ChangeDestination(ctx, id, version, newOrder)
+	if err != nil && deadlineExceeded(ctx) {
+		ErrorGatewayTimeout(w, CodeTimeout, err)
+		return
+	}
+	if err == ErrOrderNotFound {
+		ErrorNotFound(w, CodeOrderNotFound, err)
+		return
+	}
+	// taken or cancelled while the route was measured
+	if stateErr, ok := err.(*OrderStateError); ok {
+		stateConflict(w, stateErr.Status)
+		return
+	}
+	if err == ErrVersionMismatch {
+		ErrorConflict(w, CodeVersionMismatch, "Order has changed since the version given")
+		return
+	}
+	if err != nil {
+		ErrorDatabase(w, CodeDatabaseError, err)
+		return
+	}
+	s.publish(EventDestinationChanged, id, o.Status)
+
+	// marshal response
+	blob, err := json.Marshal(o.toResponse(units))
+	if err != nil {
+		ErrorJSONMarshal(w, CodeInternalError, err)
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(blob)
+	return
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChangeDestinationConflicts(t *testing.T) {
+	tests := []struct {
+		status       string
+		changeErr    error
+		wantStatus   int
+		wantCode     string
+		wantMeasured bool
+	}{
+		{StatusUnassign, nil, 200, "", true},
+		// refused before spending a maps call
+		{StatusTaken, nil, 409, CodeOrderAlreadyTaken, false},
+		{StatusDelivered, nil, 409, CodeOrderAlreadyDelivered, false},
+		{StatusCancelled, nil, 409, CodeOrderCancelled, false},
+		// taken while the route was measured
+		{StatusUnassign, &OrderStateError{Status: StatusTaken}, 409, CodeOrderAlreadyTaken, true},
+		{StatusUnassign, ErrVersionMismatch, 409, CodeVersionMismatch, true},
+	}
+	for _, test := range tests {
+		changed := false
+		provider := &fakeMaps{respond: matrixOf("OK", 2000)}
+		s := newTestServices(&fakeStore{
+			getOrder: func(id int64) (Order, error) {
+				return Order{Id: int(id), Status: test.status, Mode: "driving", Route: []string{"1.000000,1.000000", "1.000000,1.010000"}}, nil
+			},
+			changeDest: func(id, version int64, o NewOrder) (Order, error) {
+				changed = true
+				return Order{Id: int(id), Status: StatusUnassign, Distance: float64(o.Distance)}, test.changeErr
+			},
+		}, provider)
+		w := serve(s.changeDestinationHandler, "PATCH", "/order/1", `{"destination": ["1", "1.02"]}`, "id", "1")
+		if w.Code != test.wantStatus {
+			t.Errorf("%s, %v: got status %d %s, want %d", test.status, test.changeErr, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if measured := provider.calls() > 0; measured != test.wantMeasured || changed != test.wantMeasured {
+			t.Errorf("%s, %v: got measured %t changed %t, want %t", test.status, test.changeErr, measured, changed, test.wantMeasured)
+		}
+		if w.Code == 200 {
+			continue
+		}
+		var e Error
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if e.Code != test.wantCode || e.Error != test.wantCode {
+			t.Errorf("%s, %v: got %s, want %s", test.status, test.changeErr, w.Body, test.wantCode)
+		}
+	}
+}
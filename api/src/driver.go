@@ -0,0 +1,17 @@
+package main
+
+import (
+	"regexp"
+)
+
+// driver ids are opaque to the api, this only keeps them to
+// something that's safe in urls and logs
+var driverIdPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// driverIdFormat describes driverIdPattern in errors
+const driverIdFormat = "1 to 64 letters, digits, _ or -"
+
+// validDriverId reports whether id can be the id of a driver
+func validDriverId(id string) bool {
+	return driverIdPattern.MatchString(id)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// envelopeVersion is the X-API-Version clients send to get their
+// JSON responses in an envelope, any other version or none gets the
+// bodies as they've always been
+const envelopeVersion = "2"
+
+// EnvelopeResponse is the shape of every JSON response at
+// envelopeVersion. Data is what the endpoint returns without an
+// envelope, an order, a page of orders, a status and so on, and
+// Error is the usual error body when the request failed, only one
+// of them is set
+//
+//	{"data": {"id": 1, ...}, "meta": {"request_id": "..."}}
+//	{"error": {"code": "ORDER_NOT_FOUND", ...}, "meta": {"request_id": "..."}}
+type EnvelopeResponse struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error json.RawMessage `json:"error,omitempty"`
+	Meta  EnvelopeMeta    `json:"meta"`
+}
+
+type EnvelopeMeta struct {
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// Envelope wraps JSON responses in an EnvelopeResponse for clients
+// asking for envelopeVersion. Responses that aren't JSON, like CSV
+// and event streams, and bodyless ones are left as they are
+func Envelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "X-API-Version")
+		if req.Header.Get("X-API-Version") != envelopeVersion {
+			next.ServeHTTP(w, req)
+			return
+		}
+		requestId := requestIDFrom(req.Context())
+		rw := &jsonRewriter{ResponseWriter: w, rewrite: func(status int, body []byte) []byte {
+			return envelope(status, body, requestId)
+		}}
+		next.ServeHTTP(rw, req)
+		rw.close()
+	})
+}
+
+// envelope wraps the JSON body of a response with status
+func envelope(status int, body []byte, requestId string) []byte {
+	e := EnvelopeResponse{Meta: EnvelopeMeta{RequestId: requestId}}
+	if status >= 400 {
+		e.Error = body
+	} else {
+		e.Data = body
+	}
+	blob, err := json.Marshal(&e)
+	if err != nil {
+		// the handler wrote something that isn't JSON, send it as is
+		// rather than lose it
+		logger.Error("Error in wrapping response", Fields{"error": err, "request_id": requestId})
+		return body
+	}
+	return blob
+}
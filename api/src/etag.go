@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// writeCacheable writes a 200 JSON response with a weak ETag of
+// blob, or a bodyless 304 when the client already has it. The ETag
+// covers status and timestamps so it changes on every transition
+func writeCacheable(w http.ResponseWriter, req *http.Request, blob []byte) {
+	writeCacheableAs(w, req, "application/json", blob)
+}
+
+// writeCacheableAs is writeCacheable for a body of contentType, the
+// ETag of each representation is its own
+func writeCacheableAs(w http.ResponseWriter, req *http.Request, contentType string, blob []byte) {
+	sum := sha256.Sum256(blob)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(304)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(200)
+	w.Write(blob)
+}
+
+// etagMatches does the weak comparison If-None-Match calls for,
+// header can be a list of tags or *
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// order event types
+const (
+	EventPlaced    = "placed"
+	EventTaken     = "taken"
+	EventDelivered = "delivered"
+	EventCancelled = "cancelled"
+	// an unassigned order was sent somewhere else
+	EventDestinationChanged = "destination_changed"
+)
+
+// OrderEvent is a change to an order, as streamed and sent to the webhook
+type OrderEvent struct {
+	Type      string `json:"type"`
+	OrderId   int64  `json:"order_id"`
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+}
+
+// EventHub fans order events out to stream subscribers in process
+// subscribers that fall behind miss events rather than block handlers
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[chan OrderEvent]struct{}
+	// closed on shutdown so streams end and don't hold it up
+	done chan struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subs: make(map[chan OrderEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (h *EventHub) Close() {
+	close(h.done)
+}
+
+func (h *EventHub) Subscribe() chan OrderEvent {
+	ch := make(chan OrderEvent, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan OrderEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *EventHub) Publish(event OrderEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publish tells stream subscribers about an order change, taken
+// orders also go to the webhook
+func (s *Services) publish(eventType string, id int64, status string) {
+	event := OrderEvent{
+		Type:      eventType,
+		OrderId:   id,
+		Status:    apiStatus(status),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	s.Events.Publish(event)
+	if eventType == EventTaken && s.Webhook != nil {
+		s.Webhook.Send(event)
+	}
+}
+
+// streamHeartbeat keeps idle streams from being closed by proxies
+const streamHeartbeat = 15 * time.Second
+
+// streamOrdersHandler pushes order events as Server-Sent Events
+// until the client goes away
+func (s *Services) streamOrdersHandler(
+	w http.ResponseWriter,
+	req *http.Request,
+	_ httprouter.Params,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ErrorInternalServer(w, CodeInternalError, errors.New("Streaming unsupported"))
+		return
+	}
+
+	events := s.Events.Subscribe()
+	defer s.Events.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.Events.done:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-events:
+			blob, err := json.Marshal(&event)
+			if err != nil {
+				logRequestError(w, err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, blob)
+		}
+		flusher.Flush()
+	}
+}
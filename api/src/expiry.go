@@ -0,0 +1,32 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"time"
+)
+
+// expireOrders cancels orders left unassigned for longer than ttl,
+// every interval until ctx is done, then closes done
+func (s *Services) expireOrders(ctx context.Context, ttl, interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := s.Store.ExpireOrders(ctx, ttl)
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("Error in expiring orders", Fields{"error": err})
+			continue
+		}
+		logger.Info("Expired orders", Fields{"expired": expired})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"strconv"
+)
+
+// address is the readable address of p, a point of loc, reverse
+// geocoded in the language and region of loc when p is coordinates.
+// When that fails the coordinates are kept as they are.
+func (s *Services) address(ctx context.Context, loc *Location, p *Point) string {
+	if len(p.Coordinates) == 0 {
+		return p.Address
+	}
+	raw := p.String()
+	key := normalizePlace(raw) + "@" + loc.Language + "/" + loc.Region
+	if s.GeocodeCache != nil {
+		if address, ok := s.GeocodeCache.Get(key); ok {
+			return address
+		}
+	}
+
+	// validate already made sure these parse
+	lat, _ := strconv.ParseFloat(p.Coordinates[0], 64)
+	lng, _ := strconv.ParseFloat(p.Coordinates[1], 64)
+	results, err := s.Geocoder.ReverseGeocode(ctx, &maps.GeocodingRequest{
+		LatLng:   &maps.LatLng{Lat: lat, Lng: lng},
+		Language: loc.Language,
+		Region:   loc.Region,
+	})
+	if err != nil || len(results) == 0 {
+		logger.Warn("Reverse geocoding failed, keeping coordinates", Fields{"coordinates": raw, "error": err})
+		return raw
+	}
+
+	address := results[0].FormattedAddress
+	if s.GeocodeCache != nil {
+		s.GeocodeCache.Add(key, address)
+	}
+	return address
+}
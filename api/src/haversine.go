@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+const earthRadiusMeters = 6371000
+
+// haversineMeters is the great-circle distance between two points
+func haversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// latLng parses the coordinates of a validated point
+func (p *Point) latLng() (float64, float64, bool) {
+	if len(p.Coordinates) != 2 {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(p.Coordinates[0], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err := strconv.ParseFloat(p.Coordinates[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// hasCoordinates reports whether a straight-line distance can be
+// computed, which isn't the case for addresses
+func (loc *Location) hasCoordinates() bool {
+	for _, point := range loc.points() {
+		if _, _, ok := point.latLng(); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// haversineDistance is the straight-line distance in meters through
+// every point, rounded to the nearest meter like the Maps API
+func (loc *Location) haversineDistance() int {
+	points := loc.points()
+	meters := 0.0
+	for i := 1; i < len(points); i++ {
+		lat1, lng1, _ := points[i-1].latLng()
+		lat2, lng2, _ := points[i].latLng()
+		meters += haversineMeters(lat1, lng1, lat2, lng2)
+	}
+	return int(math.Floor(meters + 0.5))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// health of a dependency in a deep readiness check
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// MapsHealth remembers how the last maps calls went so readiness
+// probes don't each spend quota on a call of their own
+type MapsHealth struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// record notes how a maps call went, errors over a bad request like
+// INVALID_REQUEST still mean maps answered, only mapsDown ones count
+// as a failure
+func (h *MapsHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil && mapsDown(err) {
+		h.lastFailure = time.Now()
+	} else {
+		h.lastSuccess = time.Now()
+	}
+}
+
+// mapsDown reports whether err is maps failing to serve us, it's
+// unreachable, erroring, out of quota or refusing our key
+func mapsDown(err error) bool {
+	if isTransientMapsError(err) {
+		return true
+	}
+	for _, status := range []string{"OVER_QUERY_LIMIT", "OVER_DAILY_LIMIT", "REQUEST_DENIED"} {
+		if strings.HasPrefix(err.Error(), "maps: "+status) {
+			return true
+		}
+	}
+	return false
+}
+
+// status is degraded when the last call failed, fresh is false when
+// there hasn't been a call within maxAge to go by
+func (h *MapsHealth) status(maxAge time.Duration) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last := h.lastSuccess
+	status := HealthOK
+	if h.lastFailure.After(h.lastSuccess) {
+		last = h.lastFailure
+		status = HealthDegraded
+	}
+	return status, time.Since(last) < maxAge
+}
+
+// mapsProbe is about the cheapest distance matrix request there is,
+// a single element
+var mapsProbe = &maps.DistanceMatrixRequest{
+	Origins:      []string{"0,0"},
+	Destinations: []string{"0,0.001"},
+}
+
+// mapsStatus is the health of maps, probing it when nothing has been
+// heard from it for MapsHealthInterval
+func (s *Services) mapsStatus(ctx context.Context) string {
+	status, fresh := s.MapsHealth.status(s.Config.MapsHealthInterval)
+	if fresh {
+		return status
+	}
+	_, err := s.Maps.DistanceMatrix(ctx, mapsProbe)
+	if ctx.Err() != nil {
+		return HealthDegraded
+	}
+	s.MapsHealth.record(err)
+	if err != nil {
+		return HealthDegraded
+	}
+	return HealthOK
+}
+
+// ReadyResponse is the body of a deep readiness check
+type ReadyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// deepReadyHandler is /ready?deep=true. A failing db makes it a 503,
+// failing maps only marks it DEGRADED so instances aren't all pulled
+// out of rotation over an outage they can't fix
+func (s *Services) deepReadyHandler(
+	w http.ResponseWriter,
+	req *http.Request,
+	_ httprouter.Params,
+) {
+	ctx, cancel := context.WithTimeout(req.Context(), s.Config.ReadyTimeout)
+	defer cancel()
+
+	response := &ReadyResponse{Status: "READY", Checks: map[string]string{"db": HealthOK}}
+	status := 200
+	var dbErr error
+	if dbErr = s.Store.Ping(ctx); dbErr != nil {
+		response.Checks["db"] = HealthDown
+		response.Status = "NOT_READY"
+		status = 503
+	}
+	response.Checks["maps"] = s.mapsStatus(ctx)
+	if response.Checks["maps"] != HealthOK && status == 200 {
+		response.Status = "DEGRADED"
+	}
+
+	// write response
+	if dbErr != nil {
+		logRequestError(w, fmt.Errorf("Readiness check failed: %s", dbErr))
+	}
+	blob, err := json.Marshal(response)
+	if err != nil {
+		ErrorJSONMarshal(w, CodeInternalError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(blob)
+	return
+}
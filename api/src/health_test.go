@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMapsHealthRecord(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, HealthOK},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, HealthDegraded},
+		{io.ErrUnexpectedEOF, HealthDegraded},
+		// a 5xx html page instead of json
+		{&json.SyntaxError{}, HealthDegraded},
+		{errors.New("maps: UNKNOWN_ERROR - "), HealthDegraded},
+		{errors.New("maps: OVER_QUERY_LIMIT - You have exceeded your rate-limit for this API."), HealthDegraded},
+		{errors.New("maps: OVER_DAILY_LIMIT - "), HealthDegraded},
+		{errors.New("maps: REQUEST_DENIED - The provided API key is invalid."), HealthDegraded},
+		// maps answered, the request was bad
+		{errors.New("maps: INVALID_REQUEST - "), HealthOK},
+		{errors.New("maps: MAX_ELEMENTS_EXCEEDED - "), HealthOK},
+		{errors.New("maps: NOT_FOUND - "), HealthOK},
+		{errors.New("maps: ZERO_RESULTS - "), HealthOK},
+	}
+	for _, test := range tests {
+		h := &MapsHealth{}
+		h.record(nil)
+		time.Sleep(time.Millisecond)
+		h.record(test.err)
+		got, fresh := h.status(time.Minute)
+		if got != test.want || !fresh {
+			t.Errorf("%v: got %s fresh %t, want %s", test.err, got, fresh, test.want)
+		}
+	}
+}
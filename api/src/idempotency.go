@@ -0,0 +1,18 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKey scopes the client's Idempotency-Key to the client,
+// so two clients can't see each other's orders by reusing a key
+// it's hashed so API keys aren't stored in the db
+func idempotencyKey(req *http.Request, key string) string {
+	sum := sha256.Sum256([]byte(clientKey(req) + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
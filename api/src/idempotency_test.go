@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// keyedStore is a fakeStore placing orders with ids from 1 up and
+// keeping those placed with an idempotency key
+func keyedStore() *fakeStore {
+	keys := map[string]Order{}
+	return &fakeStore{
+		createOrder: func(o NewOrder) (Order, bool, error) {
+			order := Order{Id: len(keys) + 1, Distance: float64(o.Distance), Status: StatusUnassign}
+			if o.IdempotencyKey != "" {
+				keys[o.IdempotencyKey] = order
+			}
+			return order, true, nil
+		},
+		orderByKey: func(key string) (Order, error) {
+			o, ok := keys[key]
+			if !ok {
+				return o, ErrOrderNotFound
+			}
+			return o, nil
+		},
+	}
+}
+
+func TestPlaceOrderIdempotencyKey(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1500)}
+	s := newTestServices(keyedStore(), provider)
+	place := func(addr, key string) (OrderResponse, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("POST", "/order", strings.NewReader(`{"origin": ["1", "1"], "destination": ["1", "1.01"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		s.placeOrderHandler(w, req, nil)
+		var o OrderResponse
+		json.Unmarshal(w.Body.Bytes(), &o)
+		return o, w
+	}
+
+	first, w := place("10.0.0.1:1234", "retry-me")
+	if w.Code != 200 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("got %d Idempotent-Replayed %q, want a new order", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	again, w := place("10.0.0.1:1234", "retry-me")
+	if w.Code != 200 || again.Id != first.Id || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got order %d replayed %q, want order %d replayed", again.Id, w.Header().Get("Idempotent-Replayed"), first.Id)
+	}
+	if provider.calls() != 1 {
+		t.Errorf("got %d maps calls, want the retry answered without one", provider.calls())
+	}
+
+	// keys are per client
+	other, _ := place("10.0.0.2:1234", "retry-me")
+	if other.Id == first.Id {
+		t.Errorf("another client reusing the key got order %d", other.Id)
+	}
+
+	if _, w := place("10.0.0.1:1234", strings.Repeat("k", maxIdempotencyKeyLength+1)); w.Code != 400 {
+		t.Errorf("got status %d for a key that's too long, want 400", w.Code)
+	}
+}
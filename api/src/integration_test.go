@@ -0,0 +1,119 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// the handlers end to end against the db at DB_URI, with maps stubbed
+
+// placeOrder places an order through the handler and returns its id
+func placeOrder(t *testing.T, s *Services) string {
+	w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["1", "1"], "destination": ["1", "1.01"]}`)
+	if w.Code != 200 {
+		t.Fatalf("placing an order: got %d %s", w.Code, w.Body)
+	}
+	var o OrderResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(o.Id)
+}
+
+func takeOrder(s *Services, id, driverId string) (int, string) {
+	w := serve(s.takeOrderHandler, "PUT", "/order/"+id, `{"status": "taken", "driver_id": "`+driverId+`"}`, "id", id)
+	var e Error
+	json.Unmarshal(w.Body.Bytes(), &e)
+	return w.Code, e.Code
+}
+
+func TestPlaceTakeList(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	provider := &fakeMaps{respond: matrixOf("OK", 1500)}
+	s := newTestServices(st, provider)
+
+	taken := placeOrder(t, s)
+	unassigned := placeOrder(t, s)
+	if status, code := takeOrder(s, taken, "driver-1"); status != 200 {
+		t.Fatalf("taking order %s: got %d %s", taken, status, code)
+	}
+
+	w := serve(s.listOrderHandler, "GET", "/orders?sort=created_asc", "")
+	if w.Code != 200 {
+		t.Fatalf("listing orders: got %d %s", w.Code, w.Body)
+	}
+	var page OrderListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range page.Orders {
+		got = append(got, fmt.Sprintf("%d %s %s %.0f", o.Id, o.Status, o.DriverId, o.Distance))
+	}
+	want := []string{taken + " taken driver-1 1500", unassigned + " UNASSIGN  1500"}
+	if page.Total != 2 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %d orders %q, want %q", page.Total, got, want)
+	}
+
+	w = serve(s.listOrderHandler, "GET", "/orders?status=unassign", "")
+	page = OrderListResponse{}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != 200 || page.Total != 1 || len(page.Orders) != 1 || fmt.Sprint(page.Orders[0].Id) != unassigned {
+		t.Errorf("listing unassigned orders: got %d %s, want order %s only", w.Code, w.Body, unassigned)
+	}
+	if provider.calls() != 2 {
+		t.Errorf("got %d maps calls, want one for each order placed", provider.calls())
+	}
+}
+
+func TestTakeOrderNotFoundOrConflict(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	s := newTestServices(st, &fakeMaps{respond: matrixOf("OK", 1500)})
+
+	taken := placeOrder(t, s)
+	if status, code := takeOrder(s, taken, "driver-1"); status != 200 {
+		t.Fatalf("taking order %s: got %d %s", taken, status, code)
+	}
+	delivered := placeOrder(t, s)
+	takeOrder(s, delivered, "driver-1")
+	if w := serve(s.deliverOrderHandler, "PUT", "/order/"+delivered+"/deliver", "", "id", delivered); w.Code != 200 {
+		t.Fatalf("delivering order %s: got %d %s", delivered, w.Code, w.Body)
+	}
+	cancelled := placeOrder(t, s)
+	if w := serve(s.cancelOrderHandler, "DELETE", "/order/"+cancelled, "", "id", cancelled); w.Code != 200 {
+		t.Fatalf("cancelling order %s: got %d %s", cancelled, w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing", "999999", 404, CodeOrderNotFound},
+		{"taken", taken, 409, CodeOrderAlreadyTaken},
+		{"delivered", delivered, 409, CodeOrderAlreadyDelivered},
+		{"cancelled", cancelled, 409, CodeOrderCancelled},
+	}
+	for _, test := range tests {
+		if status, code := takeOrder(s, test.id, "driver-2"); status != test.wantStatus || code != test.wantCode {
+			t.Errorf("%s order: got %d %s, want %d %s", test.name, status, code, test.wantStatus, test.wantCode)
+		}
+	}
+
+	// the refused takes left the orders alone
+	id, _ := strconv.ParseInt(taken, 10, 64)
+	o, err := st.GetOrder(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != StatusTaken || o.Driver_id == nil || *o.Driver_id != "driver-1" {
+		t.Errorf("got order %s %s by %v, want it still taken by driver-1", taken, o.Status, o.Driver_id)
+	}
+}
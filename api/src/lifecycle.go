@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+)
+
+// phaseTimer logs how long each phase of startup or shutdown takes,
+// so slow boots and slow drains show where the time goes
+type phaseTimer struct {
+	stage string
+	start time.Time
+	last  time.Time
+}
+
+// newPhaseTimer times the phases of stage from start
+func newPhaseTimer(stage string, start time.Time) *phaseTimer {
+	return &phaseTimer{stage: stage, start: start, last: start}
+}
+
+// done logs phase as finished, it took since the previous phase did
+func (t *phaseTimer) done(phase string) {
+	now := time.Now()
+	logger.Info(t.stage+" phase done", Fields{
+		"phase":       phase,
+		"duration_ms": float64(now.Sub(t.last)) / float64(time.Millisecond),
+	})
+	t.last = now
+}
+
+// elapsedMs is the time since the stage started, in milliseconds
+func (t *phaseTimer) elapsedMs() float64 {
+	return float64(time.Since(t.start)) / float64(time.Millisecond)
+}
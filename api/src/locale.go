@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+// loose checks of maps language (BCP 47, like en or pt-BR) and
+// region (ccTLD, like us or uk) codes, maps ignores unknown ones
+var (
+	languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	regionPattern   = regexp.MustCompile(`^[a-zA-Z]{2}$`)
+)
+
+// localeFromRequest reads ?language= and ?region=, falling back to
+// MAPS_LANGUAGE and MAPS_REGION
+func localeFromRequest(req *http.Request, cfg *Config) (string, string, error) {
+	language, region := cfg.MapsLanguage, cfg.MapsRegion
+	if v := req.URL.Query().Get("language"); v != "" {
+		if !languagePattern.MatchString(v) {
+			return "", "", errors.New("language must be a language code like en or pt-BR")
+		}
+		language = v
+	}
+	if v := req.URL.Query().Get("region"); v != "" {
+		if !regionPattern.MatchString(v) {
+			return "", "", errors.New("region must be a two letter region code like us")
+		}
+		region = v
+	}
+	return language, region, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// log levels, LOG_LEVEL picks the lowest one that's written
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Fields are the structured key/values of a log line
+type Fields map[string]interface{}
+
+// Logger writes one JSON object per line
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level int
+}
+
+var logger = NewLogger(os.Stderr, LevelInfo)
+
+func NewLogger(out io.Writer, level int) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+func ParseLevel(name string) (int, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+func (l *Logger) Log(level int, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		// errors marshal to {} otherwise
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = levelNames[level]
+	entry["msg"] = msg
+
+	blob, err := json.Marshal(entry)
+	if err != nil {
+		blob, _ = json.Marshal(map[string]string{
+			"level": levelNames[LevelError],
+			"msg":   "Error in marshalling log entry",
+			"error": err.Error(),
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(blob, '\n'))
+}
+
+func (l *Logger) Debug(msg string, fields Fields) { l.Log(LevelDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields Fields)  { l.Log(LevelInfo, msg, fields) }
+func (l *Logger) Warn(msg string, fields Fields)  { l.Log(LevelWarn, msg, fields) }
+func (l *Logger) Error(msg string, fields Fields) { l.Log(LevelError, msg, fields) }
+
+// statusRecorder captures what a handler wrote so it can
+// be included in the request log line
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// body bytes written, streams count everything they sent
+	bytes int64
+	err   interface{}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = 200
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// RequestLogger emits one structured access log line per request,
+// once it's done. Streams are logged when the client goes away
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req)
+
+		if rec.status == 0 {
+			rec.status = 200
+		}
+		fields := Fields{
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"client_ip":  clientHost(req),
+			"status":     rec.status,
+			"bytes":      rec.bytes,
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"request_id": requestIDFrom(req.Context()),
+		}
+		if rec.err != nil {
+			fields["error"] = fmt.Sprint(rec.err)
+		}
+
+		level := LevelInfo
+		if rec.status >= 500 {
+			level = LevelError
+		} else if rec.status >= 400 {
+			level = LevelWarn
+		}
+		logger.Log(level, "request", fields)
+	})
+}
+
+// logRequestError attaches err to the request log line, or logs
+// it on its own when w isn't wrapped by RequestLogger
+func logRequestError(w http.ResponseWriter, err interface{}) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.err = err
+		return
+	}
+	logger.Error("request error", Fields{"error": fmt.Sprint(err)})
+}
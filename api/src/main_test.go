@@ -0,0 +1,861 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// request errors are logged, they'd bury the test output. Tests
+	// running main as a process check what it logs
+	if os.Getenv("TEST_MAIN") != "1" {
+		logger = NewLogger(ioutil.Discard, LevelError)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestServices is Services as main sets it up with the default
+// config, backed by store and provider
+func newTestServices(store OrderStore, provider DistanceProvider) *Services {
+	return &Services{
+		Store:    store,
+		Maps:     provider,
+		Geocoder: HaversineProvider{},
+		Pricer:   &FarePricer{Base: 2, PerKm: 1, Currency: "USD"},
+		Config: &Config{
+			MapsProvider:    MapsProviderGoogle,
+			MapsMaxAttempts: 1,
+			DefaultUnits:    UnitsMetric,
+		},
+		Events:     NewEventHub(),
+		MapsHealth: &MapsHealth{},
+	}
+}
+
+// fakeStore answers with the funcs it's given, calling a method
+// without one panics on the nil OrderStore
+type fakeStore struct {
+	OrderStore
+	listOrders  func(f OrderFilter) ([]Order, error)
+	countOrders func(f OrderFilter) (int64, error)
+	takeOrder   func(id, version int64, driverId string) (int64, error)
+	cancelOrder func(id, version int64) (int64, error)
+	getOrder    func(id int64) (Order, error)
+	changeDest  func(id, version int64, o NewOrder) (Order, error)
+	orderStats  func(window time.Duration) (OrderStats, error)
+	createOrder func(o NewOrder) (Order, bool, error)
+	orderByKey  func(key string) (Order, error)
+}
+
+func (st *fakeStore) OrderByIdempotencyKey(ctx context.Context, key string, window time.Duration) (Order, error) {
+	return st.orderByKey(key)
+}
+
+func (st *fakeStore) CreateOrder(ctx context.Context, o NewOrder) (Order, bool, error) {
+	return st.createOrder(o)
+}
+
+func (st *fakeStore) ListOrders(ctx context.Context, f OrderFilter) ([]Order, error) {
+	return st.listOrders(f)
+}
+
+func (st *fakeStore) CountOrders(ctx context.Context, f OrderFilter) (int64, error) {
+	return st.countOrders(f)
+}
+
+func (st *fakeStore) TakeOrder(ctx context.Context, id, version int64, driverId, actor string) (int64, error) {
+	return st.takeOrder(id, version, driverId)
+}
+
+func (st *fakeStore) GetOrder(ctx context.Context, id int64) (Order, error) {
+	return st.getOrder(id)
+}
+
+func (st *fakeStore) ChangeDestination(ctx context.Context, id, version int64, o NewOrder) (Order, error) {
+	return st.changeDest(id, version, o)
+}
+
+func (st *fakeStore) OrderStats(ctx context.Context, window time.Duration) (OrderStats, error) {
+	return st.orderStats(window)
+}
+
+func (st *fakeStore) CancelOrder(ctx context.Context, id, version int64, actor string) (int64, error) {
+	return st.cancelOrder(id, version)
+}
+
+// fakeMaps answers distance matrix requests with respond, after
+// delay unless ctx is done first, and keeps the requests it was sent
+type fakeMaps struct {
+	mu       sync.Mutex
+	requests []*maps.DistanceMatrixRequest
+	respond  func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error)
+	delay    time.Duration
+}
+
+func (f *fakeMaps) DistanceMatrix(ctx context.Context, r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return f.respond(r)
+}
+
+func (f *fakeMaps) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// matrixOf answers every element of a request with status, meters
+// and a minute
+func matrixOf(status string, meters int) func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+	return func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+		resp := &maps.DistanceMatrixResponse{Rows: make([]maps.DistanceMatrixElementsRow, len(r.Origins))}
+		for i := range resp.Rows {
+			for range r.Destinations {
+				element := &maps.DistanceMatrixElement{Status: status, Duration: time.Minute}
+				element.Distance.Meters = meters
+				resp.Rows[i].Elements = append(resp.Rows[i].Elements, element)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// serve calls handle with a request for method and target, a JSON
+// body when body isn't empty, and the route params in pairs
+func serve(handle httprouter.Handle, method, target, body string, params ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var ps httprouter.Params
+	for i := 0; i+1 < len(params); i += 2 {
+		ps = append(ps, httprouter.Param{Key: params[i], Value: params[i+1]})
+	}
+	w := httptest.NewRecorder()
+	handle(w, req, ps)
+	return w
+}
+
+func TestTakeOrderConcurrently(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	s := newTestServices(st, nil)
+
+	o := placeTestOrder(t, st)
+	id := fmt.Sprint(o.Id)
+	const takers = 10
+	statuses := make(chan int, takers)
+	var wg sync.WaitGroup
+	for i := 0; i < takers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"status": "taken", "driver_id": "driver-%d"}`, i)
+			statuses <- serve(s.takeOrderHandler, "PUT", "/order/"+id, body, "id", id).Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != takers-1 {
+		t.Errorf("got statuses %v, want one 200 and %d 409s", counts, takers-1)
+	}
+}
+
+func TestListOrdersQueryErrors(t *testing.T) {
+	failed := errors.New("query failed")
+	tests := []struct {
+		name  string
+		store *fakeStore
+	}{
+		{"list", &fakeStore{
+			listOrders: func(OrderFilter) ([]Order, error) { return nil, failed },
+		}},
+		{"count", &fakeStore{
+			listOrders:  func(OrderFilter) ([]Order, error) { return []Order{{Id: 1}}, nil },
+			countOrders: func(OrderFilter) (int64, error) { return 0, failed },
+		}},
+	}
+	for _, test := range tests {
+		s := newTestServices(test.store, nil)
+		w := serve(s.listOrderHandler, "GET", "/orders", "")
+		if w.Code != 500 || !strings.Contains(w.Body.String(), CodeDatabaseError) {
+			t.Errorf("%s error: got %d %s, want a 500 %s", test.name, w.Code, w.Body, CodeDatabaseError)
+		}
+	}
+}
+
+func TestValidateCoordinates(t *testing.T) {
+	tests := []struct {
+		name  string
+		point []string
+		want  ValidationErrors
+	}{
+		{"valid", []string{"-33.8688", "151.2093"}, nil},
+		{"bounds", []string{"90", "-180"}, nil},
+		{"lat too high", []string{"90.1", "0"}, ValidationErrors{{"origin", "latitude must be a number between -90 and 90"}}},
+		{"lat too low", []string{"-91", "0"}, ValidationErrors{{"origin", "latitude must be a number between -90 and 90"}}},
+		{"lng too high", []string{"0", "180.5"}, ValidationErrors{{"origin", "longitude must be a number between -180 and 180"}}},
+		{"lng too low", []string{"0", "-181"}, ValidationErrors{{"origin", "longitude must be a number between -180 and 180"}}},
+		{"not numbers", []string{"north", "east"}, ValidationErrors{
+			{"origin", "latitude must be a number between -90 and 90"},
+			{"origin", "longitude must be a number between -180 and 180"},
+		}},
+		{"NaN", []string{"NaN", "0"}, ValidationErrors{{"origin", "latitude must be a number between -90 and 90"}}},
+		{"empty", []string{"", "0"}, ValidationErrors{{"origin", "must be a [lat, lng] pair"}}},
+		{"one value", []string{"0"}, ValidationErrors{{"origin", "must be a [lat, lng] pair"}}},
+	}
+	for _, test := range tests {
+		got := validateCoordinates("origin", test.point)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPlaceOrderInvalidCoordinates(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+	s := newTestServices(nil, provider)
+	w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["91", "0"], "destination": ["0", "abc"]}`)
+	if w.Code != 400 {
+		t.Fatalf("got status %d, want 400", w.Code)
+	}
+	var e Error
+	json.Unmarshal(w.Body.Bytes(), &e)
+	want := ValidationErrors{
+		{"origin", "latitude must be a number between -90 and 90"},
+		{"destination", "longitude must be a number between -180 and 180"},
+	}
+	if e.Code != CodeValidationFailed || fmt.Sprint(e.Fields) != fmt.Sprint(want) {
+		t.Errorf("got %s, want %s with fields %v", w.Body, CodeValidationFailed, want)
+	}
+	if provider.calls() != 0 {
+		t.Errorf("maps was called %d times for invalid coordinates", provider.calls())
+	}
+}
+
+// orders going nowhere are rejected without asking maps
+func TestPlaceOrderSamePlace(t *testing.T) {
+	tests := []struct {
+		origin, destination string
+		want                int
+	}{
+		{`["1.5", "2.5"]`, `["1.5", "2.5"]`, 400},
+		{`["1.5", "2.5"]`, `[" 1.50000", "2.5"]`, 400},
+		{`["1.5", "2.5"]`, `["1.50000001", "2.5"]`, 400},
+		{`["1.5", "2.5"]`, `["1.5000002", "2.5"]`, 200},
+		{`["1.5", "2.5"]`, `["2.5", "1.5"]`, 200},
+	}
+	for _, test := range tests {
+		provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+		s := newTestServices(createdStore(), provider)
+		w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": `+test.origin+`, "destination": `+test.destination+`}`)
+		if w.Code != test.want {
+			t.Errorf("%s to %s: got status %d, want %d", test.origin, test.destination, w.Code, test.want)
+			continue
+		}
+		if test.want != 400 {
+			continue
+		}
+		var e Error
+		json.Unmarshal(w.Body.Bytes(), &e)
+		want := ValidationErrors{{"destination", "must differ from origin"}}
+		if e.Code != CodeValidationFailed || fmt.Sprint(e.Fields) != fmt.Sprint(want) {
+			t.Errorf("%s to %s: got %s, want %s with fields %v", test.origin, test.destination, w.Body, CodeValidationFailed, want)
+		}
+		if provider.calls() != 0 {
+			t.Errorf("%s to %s: maps was called %d times", test.origin, test.destination, provider.calls())
+		}
+	}
+}
+
+func TestErrorHandlers(t *testing.T) {
+	router := httprouter.New()
+	setErrorHandlers(router)
+	ok := func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {}
+	router.PUT("/order/:id", ok)
+	router.GET("/order/:id", ok)
+	router.DELETE("/order/:id", ok)
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantCode     string
+		wantAllow    string
+	}{
+		{"GET", "/nowhere", 404, CodeEndpointNotFound, ""},
+		{"GET", "/order/1/nowhere", 404, CodeEndpointNotFound, ""},
+		{"POST", "/order/1", 405, CodeMethodNotAllowed, "GET, PUT, DELETE"},
+		{"PATCH", "/order/1", 405, CodeMethodNotAllowed, "GET, PUT, DELETE"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		var e Error
+		err := json.Unmarshal(w.Body.Bytes(), &e)
+		if w.Code != test.wantStatus || err != nil || e.Code != test.wantCode {
+			t.Errorf("%s %s: got %d %s, want %d %s", test.method, test.path, w.Code, w.Body, test.wantStatus, test.wantCode)
+		}
+		if got := w.Header().Get("Allow"); got != test.wantAllow {
+			t.Errorf("%s %s: got Allow %q, want %q", test.method, test.path, got, test.wantAllow)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s %s: got Content-Type %q, want application/json", test.method, test.path, got)
+		}
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"application/json", `{}`, true},
+		{"application/json; charset=utf-8", `{}`, true},
+		{"Application/JSON", `{}`, true},
+		{"text/plain", `{}`, false},
+		{"", `{}`, false},
+		{"application/json;;", `{}`, false},
+		// bodyless requests don't need one
+		{"", "", true},
+		{"text/plain", "", true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("PUT", "/order/1", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+		got := requireJSON(w, req)
+		if got != test.want {
+			t.Errorf("%q with body %q: got %t, want %t", test.contentType, test.body, got, test.want)
+		}
+		if !got && (w.Code != 415 || !strings.Contains(w.Body.String(), CodeUnsupportedMediaType)) {
+			t.Errorf("%q: got %d %s, want a 415 %s", test.contentType, w.Code, w.Body, CodeUnsupportedMediaType)
+		}
+	}
+}
+
+func TestPlaceOrderTextPlain(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+	s := newTestServices(nil, provider)
+	req := httptest.NewRequest("POST", "/order", strings.NewReader(`{"origin": ["1", "1"], "destination": ["1", "1.01"]}`))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	s.placeOrderHandler(w, req, nil)
+	if w.Code != 415 {
+		t.Errorf("got status %d, want 415", w.Code)
+	}
+	if provider.calls() != 0 {
+		t.Errorf("maps was called for a rejected request")
+	}
+}
+
+// listStore is a fakeStore with no orders that keeps the filter it
+// was listed with
+func listStore(got *OrderFilter) *fakeStore {
+	return &fakeStore{
+		listOrders: func(f OrderFilter) ([]Order, error) {
+			*got = f
+			return nil, nil
+		},
+		countOrders: func(OrderFilter) (int64, error) { return 0, nil },
+	}
+}
+
+func TestListOrdersPaging(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantLimit  int64
+		wantOffset int64
+		wantPage   int64
+	}{
+		{"", 200, defaultListLimit, 0, 0},
+		{"?page=2", 200, defaultListLimit, 40, 2},
+		{"?limit=5", 200, 5, 0, 0},
+		{"?page=3&limit=1000", 200, 1000, 3000, 3},
+		{"?limit=0", 400, 0, 0, 0},
+		{"?limit=-1", 400, 0, 0, 0},
+		{"?limit=1001", 400, 0, 0, 0},
+		{"?limit=ten", 400, 0, 0, 0},
+		{"?page=-1", 400, 0, 0, 0},
+		// up to maxListOffset orders can be skipped
+		{"?page=5000", 200, defaultListLimit, maxListOffset, 5000},
+		{"?page=5001", 400, 0, 0, 0},
+		{"?page=100000&limit=1", 200, 1, maxListOffset, 100000},
+		{"?page=100001&limit=1", 400, 0, 0, 0},
+		{"?page=100&limit=1000", 200, 1000, maxListOffset, 100},
+		{"?page=101&limit=1000", 400, 0, 0, 0},
+		// page * limit would overflow an int64
+		{"?page=9223372036854775807&limit=2", 400, 0, 0, 0},
+		{"?page=4611686018427387904&limit=2", 400, 0, 0, 0},
+		{"?page=9223372036854775808", 400, 0, 0, 0},
+	}
+	for _, test := range tests {
+		var got OrderFilter
+		s := newTestServices(listStore(&got), nil)
+		w := serve(s.listOrderHandler, "GET", "/orders"+test.query, "")
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d, want %d", test.query, w.Code, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		if got.Limit != test.wantLimit || got.Offset != test.wantOffset {
+			t.Errorf("%q: got limit %d offset %d, want %d %d", test.query, got.Limit, got.Offset, test.wantLimit, test.wantOffset)
+		}
+		var page OrderListResponse
+		json.Unmarshal(w.Body.Bytes(), &page)
+		if page.Page != test.wantPage || page.Limit != test.wantLimit || page.Orders == nil {
+			t.Errorf("%q: got %s, want page %d of %d orders", test.query, w.Body, test.wantPage, test.wantLimit)
+		}
+	}
+}
+
+func TestListOrdersDistanceRange(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantMin    string
+		wantMax    string
+	}{
+		{"", 200, "<nil>", "<nil>"},
+		{"?min_distance=1000", 200, "1000", "<nil>"},
+		{"?max_distance=2500.5", 200, "<nil>", "2500.5"},
+		{"?min_distance=0&max_distance=0", 200, "0", "0"},
+		{"?min_distance=1000&max_distance=1000", 200, "1000", "1000"},
+		// meters whatever the units
+		{"?max_distance=1000&units=imperial", 200, "<nil>", "1000"},
+		{"?min_distance=1001&max_distance=1000", 400, "", ""},
+		{"?min_distance=-1", 400, "", ""},
+		{"?max_distance=far", 400, "", ""},
+		{"?max_distance=NaN", 400, "", ""},
+		{"?min_distance=Inf", 400, "", ""},
+	}
+	for _, test := range tests {
+		var got OrderFilter
+		s := newTestServices(listStore(&got), nil)
+		w := serve(s.listOrderHandler, "GET", "/orders"+test.query, "")
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d %s, want %d", test.query, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			if !strings.Contains(w.Body.String(), CodeInvalidParameters) {
+				t.Errorf("%q: got %s, want %s", test.query, w.Body, CodeInvalidParameters)
+			}
+			continue
+		}
+		if min, max := floatString(got.MinDistance), floatString(got.MaxDistance); min != test.wantMin || max != test.wantMax {
+			t.Errorf("%q: got range %s to %s, want %s to %s", test.query, min, max, test.wantMin, test.wantMax)
+		}
+	}
+}
+
+func TestListOrdersCreatedRange(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantAfter  string
+		wantBefore string
+	}{
+		{"", 200, "<nil>", "<nil>"},
+		// either bound can be left open
+		{"?created_after=2018-09-01T00:00:00Z", 200, "2018-09-01T00:00:00Z", "<nil>"},
+		{"?created_before=2018-09-01T00:00:00Z", 200, "<nil>", "2018-09-01T00:00:00Z"},
+		{"?created_after=2018-09-01T00:00:00Z&created_before=2018-09-01T00:00:00Z", 200, "2018-09-01T00:00:00Z", "2018-09-01T00:00:00Z"},
+		{"?created_after=2018-09-01T02:00:00%2B02:00", 200, "2018-09-01T00:00:00Z", "<nil>"},
+		{"?created_after=2018-09-02T00:00:00Z&created_before=2018-09-01T00:00:00Z", 400, "", ""},
+		{"?created_after=2018-09-01", 400, "", ""},
+		{"?created_before=yesterday", 400, "", ""},
+	}
+	for _, test := range tests {
+		var got OrderFilter
+		s := newTestServices(listStore(&got), nil)
+		w := serve(s.listOrderHandler, "GET", "/orders"+test.query, "")
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d %s, want %d", test.query, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			if !strings.Contains(w.Body.String(), CodeInvalidParameters) {
+				t.Errorf("%q: got %s, want %s", test.query, w.Body, CodeInvalidParameters)
+			}
+			continue
+		}
+		if after, before := timeString(got.CreatedAfter), timeString(got.CreatedBefore); after != test.wantAfter || before != test.wantBefore {
+			t.Errorf("%q: got range %s to %s, want %s to %s", test.query, after, before, test.wantAfter, test.wantBefore)
+		}
+	}
+}
+
+func timeString(t *time.Time) string {
+	if t == nil {
+		return "<nil>"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func floatString(f *float64) string {
+	if f == nil {
+		return "<nil>"
+	}
+	return fmt.Sprint(*f)
+}
+
+// CSV pages are cacheable like JSON ones, with an ETag of their own
+func TestListOrdersCSV(t *testing.T) {
+	s := newTestServices(&fakeStore{
+		listOrders: func(OrderFilter) ([]Order, error) {
+			return []Order{{Id: 1, Distance: 1500, Status: StatusUnassign, Mode: "driving"}}, nil
+		},
+		countOrders: func(OrderFilter) (int64, error) { return 1, nil },
+	}, nil)
+	get := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.Header.Set("Accept", accept)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		s.listOrderHandler(w, req, nil)
+		return w
+	}
+
+	w := get("text/csv", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || etag == "" {
+		t.Fatalf("got %d with headers %v, want a 200 CSV with an ETag", w.Code, w.Header())
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 2 || lines[0] != strings.Join(exportHeader, ",") {
+		t.Errorf("got %q, want the header and one order", w.Body)
+	}
+	if w := get("text/csv", etag); w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("got %d %q for a CSV page the client has, want a bodyless 304", w.Code, w.Body)
+	}
+	if w := get("application/json", etag); w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("got %d with ETag %q for JSON, want a 200 with an ETag other than the CSV one", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestOrderCursor(t *testing.T) {
+	c := OrderCursor{CreatedAt: time.Date(2018, 9, 11, 3, 36, 14, 630248000, time.UTC), Id: 42}
+	got, err := parseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.Id != c.Id {
+		t.Errorf("got %+v back, want %+v", got, c)
+	}
+
+	for _, s := range []string{"42", "!!", OrderCursor{Id: 0}.String(), "MTIzLmFiYw"} {
+		if _, err := parseCursor(s); err == nil {
+			t.Errorf("parsed %q, want an error", s)
+		}
+	}
+}
+
+func TestListOrdersNextCursor(t *testing.T) {
+	created := time.Date(2018, 9, 11, 0, 0, 0, 0, time.UTC)
+	var got OrderFilter
+	s := newTestServices(&fakeStore{
+		listOrders: func(f OrderFilter) ([]Order, error) {
+			got = f
+			return []Order{{Id: 9, Created_at: created}, {Id: 8, Created_at: created}}, nil
+		},
+		countOrders: func(OrderFilter) (int64, error) { return 10, nil },
+	}, nil)
+
+	w := serve(s.listOrderHandler, "GET", "/orders?limit=2", "")
+	var page OrderListResponse
+	json.Unmarshal(w.Body.Bytes(), &page)
+	want := OrderCursor{CreatedAt: created, Id: 8}.String()
+	if page.NextCursor != want {
+		t.Fatalf("got next_cursor %q, want %q", page.NextCursor, want)
+	}
+
+	w = serve(s.listOrderHandler, "GET", "/orders?limit=2&after="+page.NextCursor, "")
+	if w.Code != 200 {
+		t.Fatalf("got status %d following next_cursor", w.Code)
+	}
+	if got.After == nil || got.After.Id != 8 || !got.After.CreatedAt.Equal(created) {
+		t.Errorf("got after %+v, want the last order of the first page", got.After)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, "after="+want) {
+		t.Errorf("got Link %q, want a next link after %s", link, want)
+	}
+
+	for _, query := range []string{"?after=nope", "?after=" + want + "&page=1"} {
+		if w := serve(s.listOrderHandler, "GET", "/orders"+query, ""); w.Code != 400 {
+			t.Errorf("%q: got status %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestListOrdersSort(t *testing.T) {
+	after := OrderCursor{CreatedAt: time.Now(), Id: 8}.String()
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantSort   string
+		wantCursor bool
+	}{
+		{"", 200, SortCreatedDesc, true},
+		{"?sort=created_desc", 200, SortCreatedDesc, true},
+		{"?sort=CREATED_ASC", 200, SortCreatedAsc, false},
+		{"?sort=distance_desc", 200, SortDistanceDesc, false},
+		{"?sort=distance_asc", 200, SortDistanceAsc, false},
+		{"?sort=id; DROP TABLE delivery_order", 400, "", false},
+		{"?sort=distance", 400, "", false},
+		// cursors only follow the newest first sort
+		{"?sort=distance_asc&after=" + after, 400, "", false},
+	}
+	for _, test := range tests {
+		var got OrderFilter
+		s := newTestServices(&fakeStore{
+			listOrders: func(f OrderFilter) ([]Order, error) {
+				got = f
+				return []Order{{Id: 1, Created_at: time.Now()}}, nil
+			},
+			countOrders: func(OrderFilter) (int64, error) { return 5, nil },
+		}, nil)
+		req := httptest.NewRequest("GET", "/orders?limit=1", nil)
+		req.URL.RawQuery = strings.TrimPrefix(test.query, "?") + "&limit=1"
+		w := httptest.NewRecorder()
+		s.listOrderHandler(w, req, nil)
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d, want %d", test.query, w.Code, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var page OrderListResponse
+		json.Unmarshal(w.Body.Bytes(), &page)
+		if got.Sort != test.wantSort || (page.NextCursor != "") != test.wantCursor {
+			t.Errorf("%q: got sort %q next_cursor %q, want %q with a cursor %t", test.query, got.Sort, page.NextCursor, test.wantSort, test.wantCursor)
+		}
+	}
+}
+
+func TestTakeOrderConflicts(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		// as it was before codes
+		{&OrderStateError{Status: StatusTaken}, `{"code":"ORDER_ALREADY_BEEN_TAKEN","error":"ORDER_ALREADY_BEEN_TAKEN"}`},
+		{&OrderStateError{Status: StatusDelivered}, `{"code":"ORDER_ALREADY_BEEN_DELIVERED","error":"ORDER_ALREADY_BEEN_DELIVERED"}`},
+		{&OrderStateError{Status: StatusCancelled}, `{"code":"ORDER_CANCELLED","error":"ORDER_CANCELLED"}`},
+		{ErrVersionMismatch, `{"code":"ORDER_VERSION_MISMATCH","error":"ORDER_VERSION_MISMATCH"}`},
+	}
+	for _, test := range tests {
+		s := newTestServices(&fakeStore{
+			takeOrder: func(int64, int64, string) (int64, error) { return 0, test.err },
+		}, nil)
+		w := serve(s.takeOrderHandler, "PUT", "/order/1", `{"status": "taken", "driver_id": "driver-1"}`, "id", "1")
+		if w.Code != 409 || w.Body.String() != test.want {
+			t.Errorf("%v: got %d %s, want 409 %s", test.err, w.Code, w.Body, test.want)
+		}
+	}
+}
+
+func TestTakeOrderBody(t *testing.T) {
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantCode   string
+		wantFields ValidationErrors
+	}{
+		{`{"status": "taken", "driver_id": "driver-1"}`, 200, "", nil},
+		{`{"status": "taken", "driver_id": "driver-1", "version": 3}`, 200, "", nil},
+		{"", 400, CodeMalformedRequest, nil},
+		{"  ", 400, CodeMalformedRequest, nil},
+		{`{"status": "taken", "driver_id": `, 400, CodeMalformedRequest, nil},
+		{`{"status": 1, "driver_id": "driver-1"}`, 400, CodeMalformedRequest, nil},
+		{`{"status": "taken", "driver_id": "driver-1", "driver": "x"}`, 400, CodeValidationFailed, ValidationErrors{{"driver", "is not a known field"}}},
+		// encoding/json would take these for status and driver_id
+		{`{"Status": "taken", "driver_id": "driver-1"}`, 400, CodeValidationFailed, ValidationErrors{{"Status", "is not a known field"}}},
+		{`{"status": "taken", "Driver_Id": "driver-1"}`, 400, CodeValidationFailed, ValidationErrors{{"Driver_Id", "is not a known field"}}},
+		{`{"status": "TAKEN", "driver_id": "driver-1"}`, 400, CodeValidationFailed, ValidationErrors{{"status", `must be "taken"`}}},
+		{`{}`, 400, CodeValidationFailed, ValidationErrors{{"status", `must be "taken"`}, {"driver_id", "must be " + driverIdFormat}}},
+	}
+	for _, test := range tests {
+		s := newTestServices(&fakeStore{
+			takeOrder: func(int64, int64, string) (int64, error) { return 2, nil },
+		}, nil)
+		w := serve(s.takeOrderHandler, "PUT", "/order/1", test.body, "id", "1")
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d %s, want %d", test.body, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if w.Code == 200 {
+			continue
+		}
+		var e Error
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if e.Code != test.wantCode || fmt.Sprint(e.Fields) != fmt.Sprint(test.wantFields) {
+			t.Errorf("%q: got %s, want %s with fields %v", test.body, w.Body, test.wantCode, test.wantFields)
+		}
+	}
+}
+
+func TestTakeOrderDriverId(t *testing.T) {
+	tests := []struct {
+		driverId   string
+		wantStatus int
+	}{
+		{"driver-1", 200},
+		{"D_42", 200},
+		{strings.Repeat("d", 64), 200},
+		{"", 400},
+		{strings.Repeat("d", 65), 400},
+		{"driver 1", 400},
+		{"driver/1", 400},
+	}
+	for _, test := range tests {
+		var got string
+		s := newTestServices(&fakeStore{
+			takeOrder: func(id, version int64, driverId string) (int64, error) {
+				got = driverId
+				return 2, nil
+			},
+		}, nil)
+		body, _ := json.Marshal(map[string]string{"status": "taken", "driver_id": test.driverId})
+		w := serve(s.takeOrderHandler, "PUT", "/order/1", string(body), "id", "1")
+		if w.Code != test.wantStatus {
+			t.Errorf("%q: got status %d %s, want %d", test.driverId, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			want := ValidationErrors{{"driver_id", "must be " + driverIdFormat}}
+			var e Error
+			json.Unmarshal(w.Body.Bytes(), &e)
+			if fmt.Sprint(e.Fields) != fmt.Sprint(want) || got != "" {
+				t.Errorf("%q: got %s, store got %q, want fields %v", test.driverId, w.Body, got, want)
+			}
+			continue
+		}
+		var status Status
+		json.Unmarshal(w.Body.Bytes(), &status)
+		if got != test.driverId || status.DriverId != test.driverId {
+			t.Errorf("%q: store got %q and the response has %q", test.driverId, got, status.DriverId)
+		}
+	}
+}
+
+func TestCancelOrderConflicts(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		want       string
+	}{
+		{&OrderStateError{Status: StatusTaken}, 409, `{"code":"ORDER_ALREADY_BEEN_TAKEN","error":"ORDER_ALREADY_BEEN_TAKEN"}`},
+		{&OrderStateError{Status: StatusDelivered}, 409, `{"code":"ORDER_ALREADY_BEEN_DELIVERED","error":"ORDER_ALREADY_BEEN_DELIVERED"}`},
+		{ErrVersionMismatch, 409, `{"code":"ORDER_VERSION_MISMATCH","error":"ORDER_VERSION_MISMATCH"}`},
+		// cancelling twice is fine
+		{&OrderStateError{Status: StatusCancelled, Version: 2}, 200, `{"status":"SUCCESS","version":2}`},
+	}
+	for _, test := range tests {
+		s := newTestServices(&fakeStore{
+			cancelOrder: func(int64, int64) (int64, error) { return 2, test.err },
+		}, nil)
+		w := serve(s.cancelOrderHandler, "DELETE", "/order/1", "", "id", "1")
+		if w.Code != test.wantStatus || strings.TrimSpace(w.Body.String()) != test.want {
+			t.Errorf("%v: got %d %s, want %d %s", test.err, w.Code, w.Body, test.wantStatus, test.want)
+		}
+	}
+}
+
+// createdStore is a fakeStore placing orders as given with id 1
+func createdStore() *fakeStore {
+	return &fakeStore{createOrder: func(o NewOrder) (Order, bool, error) {
+		return Order{Id: 1, Distance: float64(o.Distance), Status: StatusUnassign, Duration_seconds: o.DurationSeconds, Mode: o.Mode, Price_cents: &o.PriceCents, Currency: &o.Currency}, true, nil
+	}}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	valid := `{"origin": ["1", "1"], "destination": ["1", "1.01"]}`
+	tests := []struct {
+		name       string
+		body       string
+		respond    func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error)
+		store      *fakeStore
+		wantStatus int
+		wantCode   string
+	}{
+		{"placed", valid, matrixOf("OK", 1500), createdStore(), 200, ""},
+		{"empty body", "", matrixOf("OK", 1500), nil, 400, CodeMalformedRequest},
+		{"malformed", `{"origin": [1, `, matrixOf("OK", 1500), nil, 400, CodeMalformedRequest},
+		{"missing destination", `{"origin": ["1", "1"]}`, matrixOf("OK", 1500), nil, 400, CodeValidationFailed},
+		{"bad mode", `{"origin": ["1", "1"], "destination": ["1", "1.01"], "mode": "flying"}`, matrixOf("OK", 1500), nil, 400, CodeValidationFailed},
+		{"not found", valid, matrixOf("NOT_FOUND", 0), nil, 400, CodeInvalidCoordinates},
+		{"no route", valid, matrixOf("ZERO_RESULTS", 0), nil, 422, CodeRouteNotFound},
+		{"maps denied", valid, func(*maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+			return nil, errors.New("maps: REQUEST_DENIED - The provided API key is invalid.")
+		}, nil, 502, CodeMapsUnavailable},
+		{"maps incomplete", valid, func(*maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+			return &maps.DistanceMatrixResponse{}, nil
+		}, nil, 502, CodeMapsUnavailable},
+		{"db down", valid, matrixOf("OK", 1500), &fakeStore{createOrder: func(NewOrder) (Order, bool, error) {
+			return Order{}, false, errors.New("insert failed")
+		}}, 500, CodeDatabaseError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var store OrderStore
+			if test.store != nil {
+				store = test.store
+			}
+			s := newTestServices(store, &fakeMaps{respond: test.respond})
+			req := httptest.NewRequest("POST", "/order", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.placeOrderHandler(w, req, nil)
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+			if test.wantStatus != 200 {
+				var e Error
+				json.Unmarshal(w.Body.Bytes(), &e)
+				if e.Code != test.wantCode {
+					t.Errorf("got code %q, want %q", e.Code, test.wantCode)
+				}
+				return
+			}
+			var o OrderResponse
+			json.Unmarshal(w.Body.Bytes(), &o)
+			// 2 + 1.5km at 1 a km
+			if o.Id != 1 || o.Distance != 1500 || o.Duration == nil || *o.Duration != 60 || o.Price == nil || *o.Price != 3.5 || o.Mode != "driving" {
+				t.Errorf("got %s, want the 1.5km order", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// distanceMatrix calls the Maps API, retrying transient failures
+// responses are served from the cache when it's enabled
+func (s *Services) distanceMatrix(
+	ctx context.Context,
+	r *maps.DistanceMatrixRequest,
+) (*maps.DistanceMatrixResponse, error) {
+	var key string
+	if s.Cache != nil {
+		key = distanceCacheKey(r)
+		if resp, ok := s.Cache.Get(key); ok {
+			distanceCacheRequests.WithLabelValues("hit").Inc()
+			return resp, nil
+		}
+		distanceCacheRequests.WithLabelValues("miss").Inc()
+	}
+
+	var resp *maps.DistanceMatrixResponse
+	err := retry(ctx, s.Config.MapsMaxAttempts, s.Config.MapsRetryBackoff, isTransientMapsError, func() error {
+		var err error
+		resp, err = s.Maps.DistanceMatrix(ctx, r)
+		return err
+	})
+	if err == nil && s.Cache != nil {
+		s.Cache.Add(key, resp)
+	}
+	// running out of time says nothing about maps
+	if ctx.Err() == nil {
+		s.MapsHealth.record(err)
+	}
+	return resp, err
+}
+
+// Route is the measured distance of an order
+type Route struct {
+	Distance int
+	// nil when maps didn't return a duration for every leg
+	Duration *int64
+	// true when the distance is a straight-line fallback
+	Estimated bool
+}
+
+// RouteError is why a route couldn't be measured, with the status
+// and code to answer the client with
+type RouteError struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+func (e *RouteError) Error() string {
+	return e.Err.Error()
+}
+
+// newOrder is what gets stored for loc going along r
+func (r Route) newOrder(loc *Location) NewOrder {
+	var trafficModel *string
+	if loc.TrafficModel != "" {
+		trafficModel = &loc.TrafficModel
+	}
+	return NewOrder{
+		Distance:        r.Distance,
+		DurationSeconds: r.Duration,
+		Estimated:       r.Estimated,
+		Mode:            string(loc.travelMode()),
+		Stops:           len(loc.Waypoints),
+		Route:           loc.route(),
+		TrafficModel:    trafficModel,
+	}
+}
+
+// tooFar reports whether route is longer than MAX_ORDER_DISTANCE_METERS
+func (s *Services) tooFar(route Route) bool {
+	return s.Config.MaxOrderDistance > 0 && route.Distance > s.Config.MaxOrderDistance
+}
+
+// measureRoute gets the distance of loc from maps, falling back to a
+// straight-line estimate when maps is down and that's enabled
+// Each leg is its own single element request, a matrix of all the
+// points would be billed for every pair of them
+// errors are all *RouteError
+func (s *Services) measureRoute(ctx context.Context, loc *Location) (Route, error) {
+	routes, errs := s.measure(ctx, []Location{*loc}, singleLegs)
+	return routes[0], errs[0]
+}
+
+// routeFromLegs sums the elements of each leg of a route, in order
+func routeFromLegs(elements []*maps.DistanceMatrixElement) (Route, error) {
+	var route Route
+	var totalDuration time.Duration
+	durationKnown := true
+	for i, element := range elements {
+		// NOT_FOUND means the coordinates couldn't be resolved (bad input)
+		// ZERO_RESULTS means they're valid but there's no route between them
+		if element.Status == "NOT_FOUND" {
+			return route, &RouteError{400, CodeInvalidCoordinates, fmt.Errorf("Distance matrix element status for leg %d: %s", i, element.Status)}
+		}
+		if element.Status != "OK" {
+			return route, &RouteError{422, CodeRouteNotFound, fmt.Errorf("Distance matrix element status for leg %d: %s", i, element.Status)}
+		}
+		route.Distance += element.Distance.Meters
+		totalDuration += element.Duration
+		// a leg without a duration makes the total unknown
+		if element.Duration <= 0 {
+			durationKnown = false
+		}
+	}
+	// a zero distance means origin and destination resolve to the same
+	// point, which isn't a delivery
+	if route.Distance == 0 {
+		return route, &RouteError{422, CodeRouteNotFound, errors.New("could not compute a positive distance between origin and destination")}
+	}
+	// duration isn't always present, store null rather than 0 then
+	if durationKnown {
+		seconds := int64(totalDuration.Seconds())
+		route.Duration = &seconds
+	}
+	return route, nil
+}
+
+// isTransientMapsError reports whether a Maps call is worth retrying
+// network failures, garbled (usually 5xx) bodies and UNKNOWN_ERROR
+// are, bad requests and quota/key problems aren't
+func isTransientMapsError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if _, ok := err.(*json.SyntaxError); ok {
+		return true
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "maps: UNKNOWN_ERROR")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMeasureRouteBadResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		respond    func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error)
+		wantStatus int
+		wantCode   string
+	}{
+		{"no rows", func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+			return &maps.DistanceMatrixResponse{}, nil
+		}, 502, CodeMapsUnavailable},
+		{"no elements", func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+			return &maps.DistanceMatrixResponse{Rows: []maps.DistanceMatrixElementsRow{{}}}, nil
+		}, 502, CodeMapsUnavailable},
+		{"ZERO_RESULTS", matrixOf("ZERO_RESULTS", 0), 422, CodeRouteNotFound},
+		{"NOT_FOUND", matrixOf("NOT_FOUND", 0), 400, CodeInvalidCoordinates},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(nil, &fakeMaps{respond: test.respond})
+			loc := Location{Origin: Point{Coordinates: []string{"1", "1"}}, Destination: Point{Coordinates: []string{"1", "1.01"}}}
+			_, err := s.measureRoute(context.Background(), &loc)
+			routeErr, ok := err.(*RouteError)
+			if !ok {
+				t.Fatalf("got error %v, want a *RouteError", err)
+			}
+			if routeErr.Status != test.wantStatus || routeErr.Code != test.wantCode {
+				t.Errorf("got %d %s, want %d %s", routeErr.Status, routeErr.Code, test.wantStatus, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderZeroResults(t *testing.T) {
+	s := newTestServices(nil, &fakeMaps{respond: matrixOf("ZERO_RESULTS", 0)})
+	w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["1", "1"], "destination": ["1", "1.01"]}`)
+	if w.Code != 422 {
+		t.Fatalf("got status %d, want 422", w.Code)
+	}
+	var e Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	if err != nil || e.Code != CodeRouteNotFound {
+		t.Errorf("got body %s, want a %s error", w.Body, CodeRouteNotFound)
+	}
+}
+
+func TestPlaceOrderZeroDistance(t *testing.T) {
+	var logs bytes.Buffer
+	defer func(l *Logger) { logger = l }(logger)
+	logger = NewLogger(&logs, LevelDebug)
+
+	s := newTestServices(nil, &fakeMaps{respond: matrixOf("OK", 0)})
+	w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["1", "1"], "destination": ["1", "1.01"]}`)
+	if w.Code != 422 {
+		t.Errorf("got status %d, want 422", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), CodeRouteNotFound) {
+		t.Errorf("got body %s, want a %s error", w.Body, CodeRouteNotFound)
+	}
+	if !strings.Contains(logs.String(), "could not compute a positive distance between origin and destination") {
+		t.Errorf("got logs %q, want the zero distance explained", logs.String())
+	}
+}
+
+func TestMeasureRouteWaypoints(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+	s := newTestServices(nil, provider)
+	loc := Location{
+		Origin:      Point{Coordinates: []string{"1", "1"}},
+		Waypoints:   []Point{{Coordinates: []string{"1", "1.01"}}, {Address: "Somewhere"}},
+		Destination: Point{Coordinates: []string{"1", "1.03"}},
+	}
+	route, err := s.measureRoute(context.Background(), &loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Distance != 3000 || route.Duration == nil || *route.Duration != 180 {
+		t.Errorf("got %+v, want the 3 legs summed", route)
+	}
+
+	// one element per leg, not every point to every other
+	legs := map[string]bool{}
+	for _, r := range provider.requests {
+		if len(r.Origins) != 1 || len(r.Destinations) != 1 {
+			t.Fatalf("got a %dx%d request, want 1x1", len(r.Origins), len(r.Destinations))
+		}
+		legs[r.Origins[0]+" to "+r.Destinations[0]] = true
+	}
+	want := []string{"1,1 to 1,1.01", "1,1.01 to Somewhere", "Somewhere to 1,1.03"}
+	if len(provider.requests) != len(want) {
+		t.Errorf("got %d requests, want %d", len(provider.requests), len(want))
+	}
+	for _, leg := range want {
+		if !legs[leg] {
+			t.Errorf("no request for the leg %s", leg)
+		}
+	}
+}
+
+func TestPlaceOrderMaxDistance(t *testing.T) {
+	tests := []struct {
+		max        int
+		meters     int
+		wantStatus int
+	}{
+		{5000, 4999, 200},
+		// the maximum itself is fine
+		{5000, 5000, 200},
+		{5000, 5001, 422},
+		// 0 is no maximum
+		{0, 20000000, 200},
+	}
+	for _, test := range tests {
+		placed := false
+		store := &fakeStore{createOrder: func(o NewOrder) (Order, bool, error) {
+			placed = true
+			return Order{Id: 1, Distance: float64(o.Distance), Status: StatusUnassign}, true, nil
+		}}
+		s := newTestServices(store, &fakeMaps{respond: matrixOf("OK", test.meters)})
+		s.Config.MaxOrderDistance = test.max
+		w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["1", "1"], "destination": ["1", "1.01"]}`)
+		if w.Code != test.wantStatus || placed != (test.wantStatus == 200) {
+			t.Errorf("%d m with a maximum of %d: got %d %s placed %t, want %d", test.meters, test.max, w.Code, w.Body, placed, test.wantStatus)
+			continue
+		}
+		if w.Code != 422 {
+			continue
+		}
+		var e Error
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if e.Code != CodeDistanceTooFar || e.Distance != test.meters || e.MaxDistance != test.max {
+			t.Errorf("%d m with a maximum of %d: got %s, want %s with both distances", test.meters, test.max, w.Body, CodeDistanceTooFar)
+		}
+	}
+}
+
+func TestPlaceOrderRouteOptions(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          string
+		defaultAvoid     []string
+		defaultTraffic   string
+		wantStatus       int
+		wantAvoid        maps.Avoid
+		wantTrafficModel maps.TrafficModel
+		wantStored       string
+	}{
+		{"none", ``, nil, "", 200, "", "", "<nil>"},
+		{"given", `, "avoid": ["Tolls", "ferries"], "traffic_model": "Pessimistic"`, nil, "", 200, "tolls|ferries", "pessimistic", "pessimistic"},
+		{"defaults", ``, []string{"highways"}, "optimistic", 200, "highways", "optimistic", "optimistic"},
+		{"given over the defaults", `, "avoid": ["tolls"], "traffic_model": "best_guess"`, []string{"highways"}, "optimistic", 200, "tolls", "best_guess", "best_guess"},
+		{"nothing to avoid over the default", `, "avoid": []`, []string{"highways"}, "", 200, "", "", "<nil>"},
+		// traffic only applies to driving
+		{"default traffic when walking", `, "mode": "walking"`, nil, "optimistic", 200, "", "", "<nil>"},
+		{"traffic when walking", `, "mode": "walking", "traffic_model": "optimistic"`, nil, "", 400, "", "", ""},
+		{"unknown feature", `, "avoid": ["boats"]`, nil, "", 400, "", "", ""},
+		{"unknown traffic model", `, "traffic_model": "realistic"`, nil, "", 400, "", "", ""},
+	}
+	for _, test := range tests {
+		var stored string
+		store := &fakeStore{createOrder: func(o NewOrder) (Order, bool, error) {
+			stored = "<nil>"
+			if o.TrafficModel != nil {
+				stored = *o.TrafficModel
+			}
+			return Order{Id: 1, Distance: float64(o.Distance), Status: StatusUnassign}, true, nil
+		}}
+		provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+		s := newTestServices(store, provider)
+		s.Config.DefaultAvoid = test.defaultAvoid
+		s.Config.DefaultTrafficModel = test.defaultTraffic
+		w := serve(s.placeOrderHandler, "POST", "/order", `{"origin": ["1", "1"], "destination": ["1", "1.01"]`+test.options+`}`)
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got %d %s, want %d", test.name, w.Code, w.Body, test.wantStatus)
+			continue
+		}
+		if w.Code != 200 {
+			if provider.calls() != 0 || !strings.Contains(w.Body.String(), CodeValidationFailed) {
+				t.Errorf("%s: got %s after %d maps calls, want %s without any", test.name, w.Body, provider.calls(), CodeValidationFailed)
+			}
+			continue
+		}
+		r := provider.requests[0]
+		if r.Avoid != test.wantAvoid || r.TrafficModel != test.wantTrafficModel || stored != test.wantStored {
+			t.Errorf("%s: sent avoid %q traffic model %q and stored %s, want %q %q %s", test.name, r.Avoid, r.TrafficModel, stored, test.wantAvoid, test.wantTrafficModel, test.wantStored)
+		}
+	}
+}
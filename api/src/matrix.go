@@ -0,0 +1,197 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"errors"
+	"sync"
+)
+
+// limits of a single distance matrix request
+const (
+	maxMatrixPlaces   = 25
+	maxMatrixElements = 100
+)
+
+// Leg is one origin to destination trip of a batch
+type Leg struct {
+	Origin       string
+	Destination  string
+	Mode         maps.Mode
+	Language     string
+	Avoid        maps.Avoid
+	TrafficModel maps.TrafficModel
+}
+
+// legs are the trips between consecutive points of loc
+func (loc *Location) legs() []Leg {
+	points := loc.points()
+	legs := make([]Leg, len(points)-1)
+	for i := range legs {
+		legs[i] = Leg{points[i].String(), points[i+1].String(), loc.travelMode(), loc.Language, loc.avoid(), maps.TrafficModel(loc.TrafficModel)}
+	}
+	return legs
+}
+
+// legChunk is the legs that share one distance matrix request,
+// a place shared by several legs is only sent once. They all have
+// the options of the first leg
+type legChunk struct {
+	first        Leg
+	origins      []string
+	destinations []string
+	originIdx    map[string]int
+	destIdx      map[string]int
+	// indexes of the legs in the batch
+	legs []int
+}
+
+func newLegChunk(first Leg) *legChunk {
+	return &legChunk{
+		first:     first,
+		originIdx: make(map[string]int),
+		destIdx:   make(map[string]int),
+	}
+}
+
+// fits reports whether leg can join c and stay within the limits
+func (c *legChunk) fits(leg Leg) bool {
+	if leg.Mode != c.first.Mode || leg.Language != c.first.Language ||
+		leg.Avoid != c.first.Avoid || leg.TrafficModel != c.first.TrafficModel {
+		return false
+	}
+	origins, destinations := len(c.origins), len(c.destinations)
+	if _, ok := c.originIdx[leg.Origin]; !ok {
+		origins++
+	}
+	if _, ok := c.destIdx[leg.Destination]; !ok {
+		destinations++
+	}
+	return origins <= maxMatrixPlaces &&
+		destinations <= maxMatrixPlaces &&
+		origins*destinations <= maxMatrixElements
+}
+
+func (c *legChunk) add(i int, leg Leg) {
+	if _, ok := c.originIdx[leg.Origin]; !ok {
+		c.originIdx[leg.Origin] = len(c.origins)
+		c.origins = append(c.origins, leg.Origin)
+	}
+	if _, ok := c.destIdx[leg.Destination]; !ok {
+		c.destIdx[leg.Destination] = len(c.destinations)
+		c.destinations = append(c.destinations, leg.Destination)
+	}
+	c.legs = append(c.legs, i)
+}
+
+// chunkLegs packs legs into as few requests as it can, in order,
+// starting a new chunk when the next leg doesn't fit
+func chunkLegs(legs []Leg) []*legChunk {
+	var chunks []*legChunk
+	var c *legChunk
+	for i, leg := range legs {
+		if c == nil || !c.fits(leg) {
+			c = newLegChunk(leg)
+			chunks = append(chunks, c)
+		}
+		c.add(i, leg)
+	}
+	return chunks
+}
+
+// singleLegs puts every leg in a chunk of its own, a request with a
+// single element
+func singleLegs(legs []Leg) []*legChunk {
+	chunks := make([]*legChunk, len(legs))
+	for i, leg := range legs {
+		chunks[i] = newLegChunk(leg)
+		chunks[i].add(i, leg)
+	}
+	return chunks
+}
+
+// distanceMatrixLegs gets the element of every leg, in order, with a
+// request for each of chunks, which are sent at once
+// a failed request fails every leg of its chunk with that error
+func (s *Services) distanceMatrixLegs(ctx context.Context, legs []Leg, chunks []*legChunk) ([]*maps.DistanceMatrixElement, []error) {
+	elements := make([]*maps.DistanceMatrixElement, len(legs))
+	errs := make([]error, len(legs))
+	var wg sync.WaitGroup
+	for _, c := range chunks {
+		wg.Add(1)
+		// chunks have legs of their own so they don't share indexes
+		go func(c *legChunk) {
+			defer wg.Done()
+			resp, err := s.distanceMatrix(ctx, &maps.DistanceMatrixRequest{
+				Origins:       c.origins,
+				Destinations:  c.destinations,
+				DepartureTime: "now",
+				Mode:          c.first.Mode,
+				Language:      c.first.Language,
+				Avoid:         c.first.Avoid,
+				TrafficModel:  c.first.TrafficModel,
+			})
+			for _, i := range c.legs {
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				row, col := c.originIdx[legs[i].Origin], c.destIdx[legs[i].Destination]
+				if len(resp.Rows) <= row || len(resp.Rows[row].Elements) <= col {
+					errs[i] = errors.New("Incomplete distance matrix response")
+					continue
+				}
+				elements[i] = resp.Rows[row].Elements[col]
+			}
+		}(c)
+	}
+	wg.Wait()
+	return elements, errs
+}
+
+// measureRoutes is measureRoute for many locations, sharing
+// distance matrix requests between them
+// errs[i] is nil or a *RouteError for locs[i]
+func (s *Services) measureRoutes(ctx context.Context, locs []Location) ([]Route, []error) {
+	return s.measure(ctx, locs, chunkLegs)
+}
+
+// measure gets the routes of locs with the requests chunk makes of
+// their legs, falling back to straight-line estimates when maps is
+// down and that's enabled
+func (s *Services) measure(ctx context.Context, locs []Location, chunk func([]Leg) []*legChunk) ([]Route, []error) {
+	var legs []Leg
+	starts := make([]int, len(locs))
+	for i := range locs {
+		starts[i] = len(legs)
+		legs = append(legs, locs[i].legs()...)
+	}
+	elements, legErrs := s.distanceMatrixLegs(ctx, legs, chunk(legs))
+
+	routes := make([]Route, len(locs))
+	errs := make([]error, len(locs))
+	for i := range locs {
+		n := len(locs[i].points()) - 1
+		var err error
+		for _, legErr := range legErrs[starts[i] : starts[i]+n] {
+			if legErr != nil {
+				err = legErr
+				break
+			}
+		}
+		if err != nil && !deadlineExceeded(ctx) && s.Config.FallbackEnabled && locs[i].hasCoordinates() {
+			// there's no duration for those
+			logger.Warn("Maps unavailable, estimating distance", Fields{"error": err})
+			routes[i] = Route{Distance: locs[i].haversineDistance(), Estimated: true}
+			continue
+		}
+		if err != nil {
+			errs[i] = &RouteError{502, CodeMapsUnavailable, err}
+			continue
+		}
+		routes[i], errs[i] = routeFromLegs(elements[starts[i] : starts[i]+n])
+		routes[i].Estimated = s.Config.MapsProvider == MapsProviderHaversine
+	}
+	return routes, errs
+}
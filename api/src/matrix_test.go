@@ -0,0 +1,183 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// legsBetween is a driving leg from each origin to the destination
+// at the same index
+func legsBetween(origins, destinations []string) []Leg {
+	legs := make([]Leg, len(origins))
+	for i := range legs {
+		legs[i] = Leg{Origin: origins[i], Destination: destinations[i], Mode: maps.TravelModeDriving}
+	}
+	return legs
+}
+
+// places is n places named prefix0 on
+func places(prefix string, n int) []string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = prefix + strconv.Itoa(i)
+	}
+	return p
+}
+
+// repeat is n times place
+func repeat(place string, n int) []string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = place
+	}
+	return p
+}
+
+func TestChunkLegs(t *testing.T) {
+	tests := []struct {
+		name string
+		legs []Leg
+		// origins x destinations of each chunk
+		want []string
+	}{
+		{"one leg", legsBetween([]string{"a"}, []string{"b"}), []string{"1x1"}},
+		{"25 origins fit", legsBetween(places("o", 25), repeat("d", 25)), []string{"25x1"}},
+		{"26 origins don't", legsBetween(places("o", 26), repeat("d", 26)), []string{"25x1", "1x1"}},
+		{"25 destinations fit", legsBetween(repeat("o", 25), places("d", 25)), []string{"1x25"}},
+		{"100 elements fit", legsBetween(append(places("o", 4), repeat("o3", 21)...), append(places("d", 4), places("e", 21)...)), []string{"4x25"}},
+		{"101 elements don't", legsBetween(places("o", 11), places("d", 11)), []string{"10x10", "1x1"}},
+		{"distinct pairs", legsBetween(places("o", 25), places("d", 25)), []string{"10x10", "10x10", "5x5"}},
+		{"shared places are sent once", legsBetween([]string{"a", "a", "b", "a"}, []string{"x", "y", "x", "x"}), []string{"2x2"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, c := range chunkLegs(test.legs) {
+			got = append(got, fmt.Sprintf("%dx%d", len(c.origins), len(c.destinations)))
+			if len(c.origins) > maxMatrixPlaces || len(c.destinations) > maxMatrixPlaces || len(c.origins)*len(c.destinations) > maxMatrixElements {
+				t.Errorf("%s: chunk of %dx%d is over the limits", test.name, len(c.origins), len(c.destinations))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got chunks %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChunkLegsOptions(t *testing.T) {
+	legs := legsBetween([]string{"a", "a", "a", "a"}, []string{"b", "c", "d", "e"})
+	legs[1].Mode = maps.TravelModeWalking
+	legs[2].Avoid = maps.AvoidTolls
+	legs[3].TrafficModel = maps.TrafficModelPessimistic
+	chunks := chunkLegs(legs)
+	if len(chunks) != 4 {
+		t.Fatalf("got %d chunks, want legs with other options in their own", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.first != legs[i] {
+			t.Errorf("chunk %d has the options of %+v, want %+v", i, c.first, legs[i])
+		}
+	}
+}
+
+// placeMatrix answers with o<i> to d<j> being i*100+j meters
+func placeMatrix(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+	resp := &maps.DistanceMatrixResponse{Rows: make([]maps.DistanceMatrixElementsRow, len(r.Origins))}
+	for i, origin := range r.Origins {
+		for _, destination := range r.Destinations {
+			o, _ := strconv.Atoi(strings.TrimPrefix(origin, "o"))
+			d, _ := strconv.Atoi(strings.TrimPrefix(destination, "d"))
+			element := &maps.DistanceMatrixElement{Status: "OK"}
+			element.Distance.Meters = o*100 + d
+			resp.Rows[i].Elements = append(resp.Rows[i].Elements, element)
+		}
+	}
+	return resp, nil
+}
+
+func TestDistanceMatrixLegs(t *testing.T) {
+	// 30 legs, some sharing places, across several requests
+	var origins, destinations []string
+	for i := 0; i < 30; i++ {
+		origins = append(origins, fmt.Sprintf("o%d", i%12))
+		destinations = append(destinations, fmt.Sprintf("d%d", i%7))
+	}
+	legs := legsBetween(origins, destinations)
+	provider := &fakeMaps{respond: placeMatrix}
+	s := newTestServices(nil, provider)
+
+	elements, errs := s.distanceMatrixLegs(context.Background(), legs, chunkLegs(legs))
+	for i := range legs {
+		want := (i%12)*100 + i%7
+		if errs[i] != nil || elements[i].Distance.Meters != want {
+			t.Errorf("leg %d: got %v, %v, want %d meters", i, elements[i], errs[i], want)
+		}
+	}
+	if provider.calls() != len(chunkLegs(legs)) {
+		t.Errorf("got %d requests, want one per chunk", provider.calls())
+	}
+}
+
+func TestDistanceMatrixLegsErrors(t *testing.T) {
+	denied := errors.New("maps: OVER_QUERY_LIMIT")
+	provider := &fakeMaps{respond: func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+		switch r.Origins[0] {
+		case "o1":
+			return nil, denied
+		case "o2":
+			// a row short
+			return &maps.DistanceMatrixResponse{}, nil
+		}
+		return placeMatrix(r)
+	}}
+	s := newTestServices(nil, provider)
+	legs := legsBetween([]string{"o0", "o1", "o2", "o3"}, []string{"d0", "d1", "d2", "d3"})
+
+	elements, errs := s.distanceMatrixLegs(context.Background(), legs, singleLegs(legs))
+	if errs[0] != nil || elements[0].Distance.Meters != 0 {
+		t.Errorf("leg 0: got %v, %v, want 0 meters", elements[0], errs[0])
+	}
+	if errs[1] != denied {
+		t.Errorf("leg 1: got %v, want the error of its request", errs[1])
+	}
+	if errs[2] == nil || elements[2] != nil {
+		t.Errorf("leg 2: got %v, %v, want an incomplete response error", elements[2], errs[2])
+	}
+	if errs[3] != nil || elements[3].Distance.Meters != 303 {
+		t.Errorf("leg 3: got %v, %v, want 303 meters", elements[3], errs[3])
+	}
+}
+
+func TestMeasureRoutesErrors(t *testing.T) {
+	provider := &fakeMaps{respond: func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+		resp, _ := matrixOf("OK", 1000)(r)
+		for i, origin := range r.Origins {
+			for j, destination := range r.Destinations {
+				if origin == "1,2" && destination == "1,2.01" {
+					resp.Rows[i].Elements[j].Status = "ZERO_RESULTS"
+				}
+			}
+		}
+		return resp, nil
+	}}
+	s := newTestServices(nil, provider)
+	locs := []Location{
+		{Origin: Point{Coordinates: []string{"1", "1"}}, Destination: Point{Coordinates: []string{"1", "1.01"}}},
+		{Origin: Point{Coordinates: []string{"1", "2"}}, Destination: Point{Coordinates: []string{"1", "2.01"}}},
+	}
+	routes, errs := s.measureRoutes(context.Background(), locs)
+	if errs[0] != nil || routes[0].Distance != 1000 {
+		t.Errorf("order 0: got %+v, %v, want 1000 meters", routes[0], errs[0])
+	}
+	if routeErr, ok := errs[1].(*RouteError); !ok || routeErr.Status != 422 || routeErr.Code != CodeRouteNotFound {
+		t.Errorf("order 1: got %v, want a 422 %s", errs[1], CodeRouteNotFound)
+	}
+	if provider.calls() != 1 {
+		t.Errorf("got %d requests, want the orders to share one", provider.calls())
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by route and status.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "status"},
+	)
+	// the order counters start from the db counts, see seedOrderCounters
+	ordersPlaced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orders_placed_total",
+		Help: "Number of orders placed, including before the api started.",
+	})
+	ordersTaken = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orders_taken_total",
+		Help: "Number of orders taken, including before the api started.",
+	})
+	distanceCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "distance_cache_requests_total",
+			Help: "Distance matrix cache lookups by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(requestDuration, ordersPlaced, ordersTaken, distanceCacheRequests)
+}
+
+// seedOrderCounters starts the order counters from what's in the db
+// so they don't drop to 0 on restart, handlers count from there. Every
+// instance starts from the same totals so they're to be aggregated
+// with max rather than sum
+func seedOrderCounters(ctx context.Context, store OrderStore) error {
+	placed, err := store.CountOrders(ctx, OrderFilter{})
+	if err != nil {
+		return err
+	}
+	// delivered orders were taken first
+	var taken int64
+	for _, status := range []string{StatusTaken, StatusDelivered} {
+		n, err := store.CountOrders(ctx, OrderFilter{Status: status})
+		if err != nil {
+			return err
+		}
+		taken += n
+	}
+	ordersPlaced.Add(float64(placed))
+	ordersTaken.Add(float64(taken))
+	return nil
+}
+
+// registerPoolMetrics exposes the db pool usage, read at scrape time
+func registerPoolMetrics(pool *pgx.ConnPool) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_connections",
+			Help: "Number of open connections in the db pool.",
+		}, func() float64 {
+			return float64(pool.Stat().CurrentConnections)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_connections_in_use",
+			Help: "Number of db pool connections acquired by handlers.",
+		}, func() float64 {
+			stat := pool.Stat()
+			return float64(stat.CurrentConnections - stat.AvailableConnections)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_connections_idle",
+			Help: "Number of open db pool connections waiting to be acquired.",
+		}, func() float64 {
+			return float64(pool.Stat().AvailableConnections)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "db_pool_max_connections",
+			Help: "Most connections the db pool opens.",
+		}, func() float64 {
+			return float64(pool.Stat().MaxConnections)
+		}),
+	)
+}
+
+// instrument records the request duration of a route
+func instrument(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		start := time.Now()
+		rec, ok := w.(*statusRecorder)
+		if !ok {
+			rec = &statusRecorder{ResponseWriter: w}
+		}
+		handle(rec, req, params)
+
+		status := rec.status
+		if status == 0 {
+			status = 200
+		}
+		requestDuration.
+			WithLabelValues(route, strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+	}
+}
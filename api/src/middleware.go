@@ -0,0 +1,44 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RequestTimeout bounds how long a handler's maps and db calls
+// can take by putting a deadline on the request context
+func RequestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// deadlineExceeded reports whether the request ran out of time,
+// in which case the error it got is a 504 rather than a 500
+func deadlineExceeded(ctx context.Context) bool {
+	return ctx.Err() == context.DeadlineExceeded
+}
+
+// MaxBodySize caps how much of a request body handlers can read,
+// reads past limit fail and handlers answer with a 413
+func MaxBodySize(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > limit {
+			ErrorRequestEntityTooLarge(w, CodeBodyTooLarge, fmt.Errorf("Request body of %d bytes is over the limit", req.ContentLength))
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bodyTooLarge reports whether err came from reading past MaxBodySize
+// http.MaxBytesReader doesn't have a typed error to check against
+func bodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
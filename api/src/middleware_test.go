@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1000), delay: time.Second}
+	s := newTestServices(nil, provider)
+	handler := RequestTimeout(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.placeOrderHandler(w, req, httprouter.Params{})
+	}))
+
+	req := httptest.NewRequest("POST", "/order", strings.NewReader(`{"origin": ["1", "1"], "destination": ["1", "1.01"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, req)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s, want the deadline to cut the maps call short", elapsed)
+	}
+	if w.Code != 504 || !strings.Contains(w.Body.String(), CodeTimeout) {
+		t.Errorf("got %d %s, want a 504 %s", w.Code, w.Body, CodeTimeout)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	provider := &fakeMaps{respond: matrixOf("OK", 1000)}
+	s := newTestServices(&fakeStore{}, provider)
+	handler := MaxBodySize(64, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.placeOrderHandler(w, req, httprouter.Params{})
+	}))
+	body := `{"origin": ["1", "1"], "destination": ["1", "1.01"], "mode": "` + strings.Repeat("x", 100) + `"}`
+
+	// told up front or only found out reading it
+	for _, chunked := range []bool{false, true} {
+		req := httptest.NewRequest("POST", "/order", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != 413 || !strings.Contains(w.Body.String(), CodeBodyTooLarge) {
+			t.Errorf("chunked %t: got %d %s, want a 413 %s", chunked, w.Code, w.Body, CodeBodyTooLarge)
+		}
+	}
+	if provider.calls() != 0 {
+		t.Errorf("maps was called for an oversized body")
+	}
+}
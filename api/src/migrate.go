@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// migrationLockId keeps several api instances starting at once
+// from applying the same migration twice
+const migrationLockId = 7207
+
+// migrate applies the .sql files in dir that aren't recorded in
+// schema_migrations yet, in name order, each in its own transaction
+func migrate(ctx context.Context, db *pgx.ConnPool, dir string) error {
+	_, err := db.ExecEx(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    text        PRIMARY KEY,
+  applied_at timestamptz NOT NULL DEFAULT now()
+)`, nil)
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations found in %s", dir)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		err := applyMigration(ctx, db, file)
+		if err != nil {
+			return fmt.Errorf("migration %s: %s", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *pgx.ConnPool, file string) error {
+	version := filepath.Base(file)
+
+	tx, err := db.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// no-op once committed
+	defer tx.Rollback()
+
+	_, err = tx.ExecEx(ctx, "SELECT pg_advisory_xact_lock($1)", nil, migrationLockId)
+	if err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.
+		QueryRowEx(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", nil, version).
+		Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+
+	sql, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// no arguments so the whole file goes as one simple query
+	_, err = tx.ExecEx(ctx, string(sql), nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecEx(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", nil, version)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	logger.Info("Applied migration", Fields{"version": version})
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// negotiate picks the offer the Accept header prefers, the first
+// offer when it's empty. false means the client accepts none of them
+func negotiate(accept string, offers ...string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	best, bestQ := "", 0.0
+	for _, r := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		for _, offer := range offers {
+			// ties go to the earlier offer
+			if q > bestQ && mediaMatches(mediaRange, offer) {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// mediaMatches reports whether offer is in the range, like */* or text/*
+func mediaMatches(mediaRange, offer string) bool {
+	if mediaRange == "*/*" || mediaRange == offer {
+		return true
+	}
+	return strings.HasSuffix(mediaRange, "/*") &&
+		strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*"))
+}
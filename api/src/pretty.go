@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// PrettyJSON indents JSON responses, all of them when always is set
+// and otherwise those of requests with ?pretty=1. It's for reading
+// responses while debugging, indented bodies are a lot bigger
+func PrettyJSON(always bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pretty, _ := strconv.ParseBool(req.URL.Query().Get("pretty"))
+		if !always && !pretty {
+			next.ServeHTTP(w, req)
+			return
+		}
+		rw := &jsonRewriter{ResponseWriter: w, rewrite: indentJSON}
+		next.ServeHTTP(rw, req)
+		rw.close()
+	})
+}
+
+// indentJSON indents body by two spaces, or returns it as is when
+// it isn't valid JSON
+func indentJSON(_ int, body []byte) []byte {
+	var buf bytes.Buffer
+	if json.Indent(&buf, body, "", "  ") != nil {
+		return body
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	indented := "{\n  \"id\": 1,\n  \"route\": [\n    \"a\",\n    \"b\"\n  ]\n}\n"
+	tests := []struct {
+		name        string
+		always      bool
+		query       string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"asked for", false, "?pretty=1", "application/json", `{"id":1,"route":["a","b"]}`, indented},
+		{"asked for with true", false, "?pretty=true", "application/json; charset=utf-8", `{"id":1,"route":["a","b"]}`, indented},
+		{"not asked for", false, "", "application/json", `{"id":1,"route":["a","b"]}`, `{"id":1,"route":["a","b"]}`},
+		{"turned off", false, "?pretty=0", "application/json", `{"id":1,"route":["a","b"]}`, `{"id":1,"route":["a","b"]}`},
+		{"always", true, "", "application/json", `{"id":1,"route":["a","b"]}`, indented},
+		{"not JSON", false, "?pretty=1", "text/csv", "id,route\n1,a\n", "id,route\n1,a\n"},
+		{"broken JSON", false, "?pretty=1", "application/json", `{"id":`, `{"id":`},
+	}
+	for _, test := range tests {
+		handler := PrettyJSON(test.always, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(201)
+			w.Write([]byte(test.body))
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/order/1"+test.query, nil))
+		if w.Code != 201 || w.Body.String() != test.want {
+			t.Errorf("%s: got %d %q, want 201 %q", test.name, w.Code, w.Body, test.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+)
+
+// Pricer quotes the fare of an order as an amount in minor units
+// (cents) and the currency it's in
+type Pricer interface {
+	Price(o NewOrder) (int64, string)
+}
+
+// FarePricer charges a base fare plus per km and per minute rates,
+// and never less than Minimum. Rates are in major units.
+type FarePricer struct {
+	Base      float64
+	PerKm     float64
+	PerMinute float64
+	Minimum   float64
+	Currency  string
+}
+
+func (p *FarePricer) Price(o NewOrder) (int64, string) {
+	fare := p.Base + p.PerKm*float64(o.Distance)/1000
+	// orders without a duration are charged by distance only
+	if o.DurationSeconds != nil {
+		fare += p.PerMinute * float64(*o.DurationSeconds) / 60
+	}
+	if fare < p.Minimum {
+		fare = p.Minimum
+	}
+	// round half up to the cent
+	return int64(math.Floor(fare*100 + 0.5)), p.Currency
+}
+
+// quote sets the price of o
+func (s *Services) quote(o *NewOrder) {
+	o.PriceCents, o.Currency = s.Pricer.Price(*o)
+}
+
+// StatusQuote is the status of an order that was only priced,
+// it's never stored
+const StatusQuote = "QUOTE"
+
+// preview is o as it would be placed, without an id
+func (o NewOrder) preview() Order {
+	return Order{
+		Distance:            float64(o.Distance),
+		Status:              StatusQuote,
+		Duration_seconds:    o.DurationSeconds,
+		Estimated:           o.Estimated,
+		Mode:                o.Mode,
+		Stops:               o.Stops,
+		Origin_address:      o.OriginAddress,
+		Destination_address: o.DestinationAddress,
+		Traffic_model:       o.TrafficModel,
+		Price_cents:         &o.PriceCents,
+		Currency:            &o.Currency,
+	}
+}
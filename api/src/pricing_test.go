@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFarePricer(t *testing.T) {
+	minutes := func(m int64) *int64 {
+		s := m * 60
+		return &s
+	}
+	tests := []struct {
+		name     string
+		pricer   FarePricer
+		distance int
+		duration *int64
+		want     int64
+	}{
+		{"base only at 0 m", FarePricer{Base: 2, PerKm: 1}, 0, nil, 200},
+		{"a km", FarePricer{Base: 2, PerKm: 1}, 1000, nil, 300},
+		{"a meter short of a km", FarePricer{Base: 2, PerKm: 1}, 999, nil, 300},
+		{"a meter over a km", FarePricer{Base: 2, PerKm: 1}, 1001, nil, 300},
+		{"half a cent rounds up", FarePricer{PerKm: 1}, 5, nil, 1},
+		{"under half a cent rounds down", FarePricer{PerKm: 1}, 4, nil, 0},
+		{"long order", FarePricer{Base: 2, PerKm: 1.5}, 1000000, nil, 150200},
+		{"per minute", FarePricer{Base: 2, PerKm: 1, PerMinute: 0.5}, 1000, minutes(10), 800},
+		{"no duration is by distance only", FarePricer{Base: 2, PerKm: 1, PerMinute: 0.5}, 1000, nil, 300},
+		{"under the minimum", FarePricer{Base: 2, PerKm: 1, Minimum: 5}, 1000, nil, 500},
+		{"at the minimum", FarePricer{Base: 2, PerKm: 1, Minimum: 3}, 1000, nil, 300},
+		{"over the minimum", FarePricer{Base: 2, PerKm: 1, Minimum: 3}, 1010, nil, 301},
+	}
+	for _, test := range tests {
+		test.pricer.Currency = "EUR"
+		cents, currency := test.pricer.Price(NewOrder{Distance: test.distance, DurationSeconds: test.duration})
+		if cents != test.want || currency != "EUR" {
+			t.Errorf("%s: got %d %s, want %d EUR", test.name, cents, currency, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"googlemaps.github.io/maps"
+
+	"context"
+	"errors"
+	"math"
+	"strings"
+)
+
+// maps providers MAPS_PROVIDER can pick
+const (
+	MapsProviderGoogle    = "google"
+	MapsProviderHaversine = "haversine"
+)
+
+// DistanceProvider is the part of the Maps client the handlers use,
+// so something other than Google can stand in for it
+// context is the standard library one to match *maps.Client
+type DistanceProvider interface {
+	DistanceMatrix(ctx context.Context, r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error)
+}
+
+// Geocoder turns coordinates into addresses
+type Geocoder interface {
+	ReverseGeocode(ctx context.Context, r *maps.GeocodingRequest) ([]maps.GeocodingResult, error)
+}
+
+var (
+	_ DistanceProvider = (*maps.Client)(nil)
+	_ Geocoder         = (*maps.Client)(nil)
+	_ DistanceProvider = HaversineProvider{}
+	_ Geocoder         = HaversineProvider{}
+)
+
+// HaversineProvider stands in for Google when developing offline,
+// distances are straight lines between coordinates and there are no
+// durations. Addresses can't be resolved and come back NOT_FOUND
+type HaversineProvider struct{}
+
+func (HaversineProvider) DistanceMatrix(ctx context.Context, r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+	resp := &maps.DistanceMatrixResponse{
+		OriginAddresses:      r.Origins,
+		DestinationAddresses: r.Destinations,
+		Rows:                 make([]maps.DistanceMatrixElementsRow, len(r.Origins)),
+	}
+	for i, origin := range r.Origins {
+		resp.Rows[i].Elements = make([]*maps.DistanceMatrixElement, len(r.Destinations))
+		for j, destination := range r.Destinations {
+			element := &maps.DistanceMatrixElement{Status: "NOT_FOUND"}
+			lat1, lng1, ok1 := parseLatLng(origin)
+			lat2, lng2, ok2 := parseLatLng(destination)
+			if ok1 && ok2 {
+				meters := haversineMeters(lat1, lng1, lat2, lng2)
+				element.Status = "OK"
+				element.Distance.Meters = int(math.Floor(meters + 0.5))
+			}
+			resp.Rows[i].Elements[j] = element
+		}
+	}
+	return resp, nil
+}
+
+func (HaversineProvider) ReverseGeocode(ctx context.Context, r *maps.GeocodingRequest) ([]maps.GeocodingResult, error) {
+	return nil, errors.New("reverse geocoding needs the google maps provider")
+}
+
+// parseLatLng reads a place formatted by Point.String
+func parseLatLng(place string) (float64, float64, bool) {
+	parts := strings.Split(place, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	p := Point{Coordinates: parts}
+	return p.latLng()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"golang.org/x/time/rate"
+
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter hands out a token bucket per client, keyed by
+// API key when APIKeys accepted one and by IP otherwise
+type RateLimiter struct {
+	rate    rate.Limit
+	burst   int
+	idleTTL time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*rateLimitedClient
+	lastSweep time.Time
+}
+
+type rateLimitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewRateLimiter(rps float64, burst int, idleTTL time.Duration) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate.Limit(rps),
+		burst:     burst,
+		idleTTL:   idleTTL,
+		clients:   make(map[string]*rateLimitedClient),
+		lastSweep: time.Now(),
+	}
+}
+
+// limiter returns the bucket for key, creating it if needed
+// idle clients are evicted here so the map can't grow unbounded
+func (rl *RateLimiter) limiter(key string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > rl.idleTTL {
+		for k, c := range rl.clients {
+			if now.Sub(c.lastSeen) > rl.idleTTL {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &rateLimitedClient{limiter: rate.NewLimiter(rl.rate, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := rl.limiter(clientKey(req)).Reserve()
+		if r.Delay() > 0 {
+			// don't consume the token, the request isn't served
+			retryAfter := r.Delay()
+			r.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			ErrorTooManyRequests(w, CodeRateLimited, errors.New("Rate limit exceeded for "+req.RemoteAddr))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// clientKey tells clients apart, by the API key APIKeys accepted or
+// else their address
+func clientKey(req *http.Request) string {
+	if key := apiKeyFrom(req.Context()); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + clientHost(req)
+}
+
+// clientHost is the address of the client without the port, ClientIP
+// has already replaced a trusted proxy's with the client's
+func clientHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	handler := APIKeys([]string{"known-1", "known-2"}, NewRateLimiter(1, 2, time.Minute).Middleware(ok))
+	send := func(addr, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.RemoteAddr = addr
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// the burst goes through, then the client is limited
+	for i := 0; i < 2; i++ {
+		if w := send("10.0.0.1:1234", ""); w.Code != 200 {
+			t.Fatalf("request %d: got status %d, want 200", i, w.Code)
+		}
+	}
+	w := send("10.0.0.1:1234", "")
+	if w.Code != 429 || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got status %d Retry-After %q, want 429 after 1", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// keys that aren't configured don't get a bucket of their own
+	if w := send("10.0.0.1:1234", "made-up"); w.Code != 429 {
+		t.Errorf("unknown key: got status %d, want 429", w.Code)
+	}
+	// configured keys do, wherever they come from
+	for _, key := range []string{"known-1", "known-2"} {
+		if w := send("10.0.0.1:1234", key); w.Code != 200 {
+			t.Errorf("key %s: got status %d, want 200", key, w.Code)
+		}
+	}
+	// and other addresses have their own
+	if w := send("10.0.0.2:1234", ""); w.Code != 200 {
+		t.Errorf("other address: got status %d, want 200", w.Code)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	var got []string
+	handler := APIKeys([]string{"known"}, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, clientKey(req))
+	}))
+	for _, key := range []string{"", "known", "made-up"} {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	want := []string{"ip:10.0.0.1", "key:known", "ip:10.0.0.1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got client key %q, want %q", got[i], want[i])
+		}
+	}
+}
+
+// clients that burst keep working after an upgrade until a limit is
+// set
+func TestLoadConfigRateLimitOff(t *testing.T) {
+	tests := []struct {
+		rps  string
+		want float64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"2.5", 2.5},
+	}
+	for _, test := range tests {
+		restore := setEnv(map[string]string{
+			"DB_URI":         "postgres://localhost/orders",
+			"MAPS_API_KEY":   "secret",
+			"RATE_LIMIT_RPS": test.rps,
+		})
+		cfg, err := loadConfig()
+		restore()
+		if err != nil {
+			t.Errorf("%q: %s", test.rps, err)
+			continue
+		}
+		if cfg.RateLimitRPS != test.want {
+			t.Errorf("%q: got %v, want %v", test.rps, cfg.RateLimitRPS, test.want)
+		}
+	}
+}
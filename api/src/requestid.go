@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/net/context"
+
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// RequestID reads X-Request-ID from the request or generates one,
+// stores it in the request context and echoes it on the response
+// the header is set before the handler runs so error helpers can
+// read it back from the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID only accepts short printable ids so clients
+// can't inject anything odd into our logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
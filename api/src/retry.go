@@ -0,0 +1,38 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"time"
+)
+
+// retry calls fn until it succeeds, returns an error that isn't
+// retryable, runs out of attempts or ctx is done
+// the wait between attempts starts at backoff and doubles each time
+func retry(
+	ctx context.Context,
+	attempts int,
+	backoff time.Duration,
+	retryable func(error) bool,
+	fn func() error,
+) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		logger.Debug("Retrying after error", Fields{
+			"attempt":     attempt,
+			"error":       err,
+			"retry_in_ms": backoff.Seconds() * 1000,
+		})
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+	"googlemaps.github.io/maps"
+
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	transient := &net.DNSError{Err: "timeout", IsTimeout: true}
+	tests := []struct {
+		name         string
+		errs         []error
+		attempts     int
+		wantAttempts int
+	}{
+		{"succeeds first", nil, 3, 1},
+		{"fails twice then succeeds", []error{transient, transient}, 3, 3},
+		{"runs out of attempts", []error{transient, transient, transient}, 3, 3},
+		{"not retryable", []error{errors.New("maps: REQUEST_DENIED - bad key")}, 3, 1},
+	}
+	for _, test := range tests {
+		calls := 0
+		err := retry(context.Background(), test.attempts, time.Millisecond, isTransientMapsError, func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		// the error of the last attempt, if it failed
+		var wantErr error
+		if test.wantAttempts <= len(test.errs) {
+			wantErr = test.errs[test.wantAttempts-1]
+		}
+		if err != wantErr || calls != test.wantAttempts {
+			t.Errorf("%s: got %v after %d calls, want %v after %d", test.name, err, calls, wantErr, test.wantAttempts)
+		}
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := retry(ctx, 5, time.Hour, func(error) bool { return true }, func() error {
+		calls++
+		cancel()
+		return errors.New("failed")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want the error after 1", err, calls)
+	}
+}
+
+func TestDistanceMatrixRetries(t *testing.T) {
+	failures := 2
+	provider := &fakeMaps{respond: func(r *maps.DistanceMatrixRequest) (*maps.DistanceMatrixResponse, error) {
+		if failures > 0 {
+			failures--
+			return nil, &net.DNSError{Err: "timeout", IsTimeout: true}
+		}
+		return matrixOf("OK", 1000)(r)
+	}}
+	s := newTestServices(nil, provider)
+	s.Config.MapsMaxAttempts = 3
+	s.Config.MapsRetryBackoff = time.Millisecond
+
+	resp, err := s.distanceMatrix(context.Background(), &maps.DistanceMatrixRequest{Origins: []string{"1,1"}, Destinations: []string{"1,1.01"}})
+	if err != nil || resp.Rows[0].Elements[0].Distance.Meters != 1000 {
+		t.Fatalf("got %v, want the third attempt's response", err)
+	}
+	if provider.calls() != 3 {
+		t.Errorf("got %d calls, want 3", provider.calls())
+	}
+}
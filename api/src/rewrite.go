@@ -0,0 +1,62 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+)
+
+// jsonRewriter holds back JSON bodies and writes what rewrite makes
+// of them once the handler is done. Other bodies, like CSV and event
+// streams, go out as they're written
+type jsonRewriter struct {
+	http.ResponseWriter
+	// rewrite gets the status and the whole body, which isn't empty
+	rewrite func(status int, body []byte) []byte
+	status  int
+	// set once the status is known to be for a JSON body
+	json bool
+	buf  []byte
+}
+
+func (rw *jsonRewriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	mediaType, _, _ := mime.ParseMediaType(rw.Header().Get("Content-Type"))
+	rw.json = mediaType == "application/json"
+	if !rw.json {
+		rw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (rw *jsonRewriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(200)
+	}
+	if !rw.json {
+		return rw.ResponseWriter.Write(b)
+	}
+	rw.buf = append(rw.buf, b...)
+	return len(b), nil
+}
+
+func (rw *jsonRewriter) Flush() {
+	if rw.json {
+		return
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes the rewritten JSON body, call it after the handler
+func (rw *jsonRewriter) close() {
+	if !rw.json {
+		return
+	}
+	rw.ResponseWriter.WriteHeader(rw.status)
+	if len(rw.buf) > 0 {
+		rw.ResponseWriter.Write(rw.rewrite(rw.status, rw.buf))
+	}
+}
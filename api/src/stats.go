@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// windows GET /stats can summarize, 24h when not given
+const (
+	defaultStatsWindow = 24 * time.Hour
+	minStatsWindow     = time.Minute
+	maxStatsWindow     = 90 * 24 * time.Hour
+)
+
+// how many windows StatsCache keeps, dashboards ask for a few at most
+const statsCacheSize = 16
+
+// StatsResponse summarizes the orders placed over the window
+type StatsResponse struct {
+	Window string `json:"window"`
+	Total  int64  `json:"total"`
+	// keyed by status as orders have it
+	ByStatus        map[string]int64 `json:"by_status"`
+	AverageDistance float64          `json:"average_distance"`
+	// m or mi depending on the requested units
+	DistanceUnit string `json:"distance_unit"`
+	// in seconds, null when no order has a duration
+	AverageDuration *float64 `json:"average_duration"`
+}
+
+func (s *Services) statsHandler(
+	w http.ResponseWriter,
+	req *http.Request,
+	_ httprouter.Params,
+) {
+	// deadline set by RequestTimeout
+	ctx := req.Context()
+
+	// response units
+	units, err := unitsFromRequest(req, s.Config.DefaultUnits)
+	if err != nil {
+		ErrorInvalidParameters(w, CodeInvalidParameters, err)
+		return
+	}
+
+	// window defaults to the last day
+	window := defaultStatsWindow
+	if v := req.URL.Query().Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window < minStatsWindow || window > maxStatsWindow {
+			ErrorInvalidParameters(w, CodeInvalidParameters, fmt.Errorf("window must be a duration like 1h between %s and %s", minStatsWindow, maxStatsWindow))
+			return
+		}
+	}
+
+	// get stats from the cache or db
+	stats, ok := OrderStats{}, false
+	if s.StatsCache != nil {
+		stats, ok = s.StatsCache.Get(window)
+	}
+	if !ok {
+		stats, err = s.Store.OrderStats(ctx, window)
+		if err != nil && deadlineExceeded(ctx) {
+			ErrorGatewayTimeout(w, CodeTimeout, err)
+			return
+		}
+		if err != nil {
+			ErrorDatabase(w, CodeDatabaseError, err)
+			return
+		}
+		if s.StatsCache != nil {
+			s.StatsCache.Add(window, stats)
+		}
+	}
+
+	// marshal response
+	response := &StatsResponse{
+		Window:          window.String(),
+		Total:           stats.Total,
+		ByStatus:        make(map[string]int64, len(stats.ByStatus)),
+		AverageDistance: math.Floor(stats.AverageDistance + 0.5),
+		DistanceUnit:    "m",
+		AverageDuration: stats.AverageDuration,
+	}
+	for status, n := range stats.ByStatus {
+		response.ByStatus[apiStatus(status)] = n
+	}
+	if units == UnitsImperial {
+		response.AverageDistance = math.Floor(stats.AverageDistance/metersPerMile*100+0.5) / 100
+		response.DistanceUnit = "mi"
+	}
+	if stats.AverageDuration != nil {
+		duration := math.Floor(*stats.AverageDuration + 0.5)
+		response.AverageDuration = &duration
+	}
+	blob, err := json.Marshal(response)
+	if err != nil {
+		ErrorJSONMarshal(w, CodeInternalError, err)
+		return
+	}
+
+	// write response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(blob)
+	return
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	duration := 90.4
+	stats := OrderStats{
+		Total:           7,
+		ByStatus:        map[string]int64{StatusUnassign: 3, StatusTaken: 2, StatusDelivered: 1, StatusCancelled: 1},
+		AverageDistance: 2333.5,
+		AverageDuration: &duration,
+	}
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantWindow time.Duration
+		want       string
+	}{
+		{"", 200, 24 * time.Hour, `{"window":"24h0m0s","total":7,"by_status":{"CANCELLED":1,"DELIVERED":1,"UNASSIGN":3,"taken":2},"average_distance":2334,"distance_unit":"m","average_duration":90}`},
+		{"?window=1h&units=imperial", 200, time.Hour, `{"window":"1h0m0s","total":7,"by_status":{"CANCELLED":1,"DELIVERED":1,"UNASSIGN":3,"taken":2},"average_distance":1.45,"distance_unit":"mi","average_duration":90}`},
+		{"?window=1m", 200, time.Minute, ""},
+		{"?window=2160h", 200, 90 * 24 * time.Hour, ""},
+		{"?window=59s", 400, 0, ""},
+		{"?window=2161h", 400, 0, ""},
+		{"?window=day", 400, 0, ""},
+		{"?units=furlongs", 400, 0, ""},
+	}
+	for _, test := range tests {
+		var got time.Duration
+		s := newTestServices(&fakeStore{orderStats: func(window time.Duration) (OrderStats, error) {
+			got = window
+			return stats, nil
+		}}, nil)
+		w := serve(s.statsHandler, "GET", "/stats"+test.query, "")
+		if w.Code != test.wantStatus || got != test.wantWindow {
+			t.Errorf("%q: got %d %s for window %s, want %d for %s", test.query, w.Code, w.Body, got, test.wantStatus, test.wantWindow)
+			continue
+		}
+		if test.want != "" && w.Body.String() != test.want {
+			t.Errorf("%q: got %s, want %s", test.query, w.Body, test.want)
+		}
+	}
+}
+
+// without orders there's no average duration to give
+func TestStatsEmpty(t *testing.T) {
+	s := newTestServices(&fakeStore{orderStats: func(time.Duration) (OrderStats, error) {
+		return OrderStats{ByStatus: map[string]int64{StatusUnassign: 0, StatusTaken: 0, StatusDelivered: 0, StatusCancelled: 0}}, nil
+	}}, nil)
+	w := serve(s.statsHandler, "GET", "/stats", "")
+	var got map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != 200 || got["total"] != 0.0 || got["average_distance"] != 0.0 || got["average_duration"] != nil {
+		t.Errorf("got %d %s, want zeroes and a null average_duration", w.Code, w.Body)
+	}
+}
+
+// each window is asked of the store once while it's cached
+func TestStatsCached(t *testing.T) {
+	calls := map[time.Duration]int{}
+	s := newTestServices(&fakeStore{orderStats: func(window time.Duration) (OrderStats, error) {
+		calls[window]++
+		return OrderStats{Total: int64(calls[window])}, nil
+	}}, nil)
+	s.StatsCache = NewStatsCache(statsCacheSize, time.Minute)
+	for _, query := range []string{"", "?window=1h", "", "?window=1h", "?window=24h"} {
+		if w := serve(s.statsHandler, "GET", "/stats"+query, ""); w.Code != 200 {
+			t.Fatalf("%q: got %d %s", query, w.Code, w.Body)
+		}
+	}
+	if calls[24*time.Hour] != 1 || calls[time.Hour] != 1 {
+		t.Errorf("got store calls %v, want one for each window", calls)
+	}
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	// the order changed since the version the client expected
+	ErrVersionMismatch = errors.New("order version mismatch")
+)
+
+// OrderStateError is returned when an order isn't in the status a
+// transition needs, Status and Version are what it's actually at
+type OrderStateError struct {
+	Status  string
+	Version int64
+}
+
+func (e *OrderStateError) Error() string {
+	return "order is " + e.Status
+}
+
+// NewOrder is what's stored when an order is placed
+type NewOrder struct {
+	Distance int
+	// nil when maps didn't return a duration
+	DurationSeconds *int64
+	Estimated       bool
+	Mode            string
+	Stops           int
+	// empty when the client didn't send one
+	IdempotencyKey string
+	// nil unless the order was placed with geocode=true
+	OriginAddress      *string
+	DestinationAddress *string
+	PriceCents         int64
+	Currency           string
+	// the points it was measured along, origin first, as sent to maps
+	Route []string
+	// nil when maps was left to its default
+	TrafficModel *string
+	// who placed it, for the audit log
+	Actor string
+}
+
+// orderSorts are the orders ListOrders can sort by and their ORDER
+// BY clauses, sort keys are only ever looked up here, never put in
+// the query. id breaks ties so pagination stays stable
+var orderSorts = map[string]string{
+	SortCreatedDesc:  "created_at DESC, id DESC",
+	SortCreatedAsc:   "created_at ASC, id ASC",
+	SortDistanceDesc: "distance DESC, id DESC",
+	SortDistanceAsc:  "distance ASC, id ASC",
+}
+
+const (
+	SortCreatedDesc  = "created_desc"
+	SortCreatedAsc   = "created_asc"
+	SortDistanceDesc = "distance_desc"
+	SortDistanceAsc  = "distance_asc"
+)
+
+// OrderCursor is the last order of a page sorted newest first, the
+// next page is the orders after it
+type OrderCursor struct {
+	CreatedAt time.Time
+	Id        int64
+}
+
+// OrderFilter picks the orders ListOrders returns, sorted by Sort,
+// newest first when it's empty
+// After is a keyset cursor and wins over Offset when set, it only
+// goes with the newest first sort
+type OrderFilter struct {
+	// empty for any status
+	Status string
+	// empty for any driver
+	DriverId string
+	// in meters, nil for no bound
+	MinDistance *float64
+	MaxDistance *float64
+	// inclusive, nil for no bound
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Sort          string
+	Limit         int64
+	Offset        int64
+	// nil for the first page
+	After *OrderCursor
+}
+
+// OrderStats summarizes the orders placed over some window
+type OrderStats struct {
+	Total int64
+	// by status as stored, every status is there
+	ByStatus map[string]int64
+	// in meters, 0 when there are no orders
+	AverageDistance float64
+	// in seconds, nil when no order has a duration
+	AverageDuration *float64
+}
+
+// OrderStore is where orders are kept, handlers only go through it
+type OrderStore interface {
+	// CreateOrder returns the order already placed with the
+	// idempotency key of o instead, and false, when there's one
+	CreateOrder(ctx context.Context, o NewOrder) (Order, bool, error)
+	// CreateOrders stores all of orders or none of them
+	CreateOrders(ctx context.Context, orders []NewOrder) ([]Order, error)
+	GetOrder(ctx context.Context, id int64) (Order, error)
+	// keys older than window are released and not found
+	OrderByIdempotencyKey(ctx context.Context, key string, window time.Duration) (Order, error)
+	// the transitions only move an order still at version, any
+	// version when it's 0, and return the version it moved to. They
+	// return ErrOrderNotFound, an *OrderStateError or
+	// ErrVersionMismatch when the order can't be moved
+	// actor is who made the change, for the audit log
+	// TakeOrder assigns the order to driverId
+	TakeOrder(ctx context.Context, id, version int64, driverId, actor string) (int64, error)
+	CancelOrder(ctx context.Context, id, version int64, actor string) (int64, error)
+	DeliverOrder(ctx context.Context, id, version int64, actor string) (int64, error)
+	// ChangeDestination replaces the route of an order still
+	// unassigned, and at version like the transitions, with the one
+	// measured in o along with its distance, duration, price, traffic
+	// model and destination address. It returns the changed order or the
+	// errors of the transitions
+	ChangeDestination(ctx context.Context, id, version int64, o NewOrder) (Order, error)
+	// CancelOrders cancels every unassigned order matching f in one
+	// go, whatever f.Status, and returns the ids it cancelled
+	CancelOrders(ctx context.Context, f OrderFilter, actor string) ([]int64, error)
+	// ExpireOrders cancels orders unassigned for longer than ttl
+	// and returns how many it cancelled
+	ExpireOrders(ctx context.Context, ttl time.Duration) (int64, error)
+	// OrderHistory is the audit log of an order, oldest first
+	OrderHistory(ctx context.Context, id int64) ([]AuditEvent, error)
+	ListOrders(ctx context.Context, f OrderFilter) ([]Order, error)
+	// CountOrders ignores the paging fields of f
+	CountOrders(ctx context.Context, f OrderFilter) (int64, error)
+	// OrderStats summarizes the orders placed in the last window
+	OrderStats(ctx context.Context, window time.Duration) (OrderStats, error)
+	// EachOrder calls fn with every order matching f, oldest first,
+	// as they're read so they're never all in memory. Paging fields
+	// are ignored and an error from fn stops the iteration
+	EachOrder(ctx context.Context, f OrderFilter, fn func(Order) error) error
+	Ping(ctx context.Context) error
+}
+
+// expiryLockId makes sure only one api instance expires orders
+// at a time
+const expiryLockId = 7208
+
+const orderColumns = "id, distance, status, created_at, duration_seconds, cancelled_at, delivered_at, estimated, mode, stops, origin_address, destination_address, price_cents, currency, version, updated_at, route, driver_id, traffic_model"
+
+// rowScanner is a pgx.Row or pgx.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner) (Order, error) {
+	var o Order
+	err := row.Scan(&o.Id, &o.Distance, &o.Status, &o.Created_at, &o.Duration_seconds, &o.Cancelled_at, &o.Delivered_at, &o.Estimated, &o.Mode, &o.Stops, &o.Origin_address, &o.Destination_address, &o.Price_cents, &o.Currency, &o.Version, &o.Updated_at, &o.Route, &o.Driver_id, &o.Traffic_model)
+	return o, err
+}
+
+// PgOrderStore keeps orders in postgres
+type PgOrderStore struct {
+	db *pgx.ConnPool
+	// calls taking longer are logged, 0 logs none
+	SlowQuery time.Duration
+}
+
+func NewPgOrderStore(db *pgx.ConnPool) *PgOrderStore {
+	return &PgOrderStore{db: db}
+}
+
+// timed logs the method of the store called at start when it took
+// over SlowQuery, without its arguments
+func (st *PgOrderStore) timed(method string, start time.Time) {
+	elapsed := time.Since(start)
+	if st.SlowQuery > 0 && elapsed > st.SlowQuery {
+		logger.Warn("Slow query", Fields{"query": method, "duration_ms": float64(elapsed) / float64(time.Millisecond)})
+	}
+}
+
+// read runs fn, a query that only reads, a second time when its
+// connection turned out to be dead. The pool drops dead connections
+// so the retry gets a fresh one. Writes aren't retried as they may
+// have been applied, handlers answer those with a 503
+func (st *PgOrderStore) read(ctx context.Context, fn func() error) error {
+	return retry(ctx, 2, 0, isConnError, fn)
+}
+
+// isConnError reports whether err means the db connection was lost,
+// as opposed to the query failing
+func isConnError(err error) bool {
+	if err == pgx.ErrDeadConn || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// withTx runs fn in a transaction, committed when fn returns nil
+// and rolled back when it fails or panics
+func (st *PgOrderStore) withTx(ctx context.Context, fn func(tx *pgx.Tx) error) error {
+	tx, err := st.db.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertEvent records a change in the audit log, it's always run in
+// the transaction of the change so the two can't diverge
+const insertEvent = "INSERT INTO order_events (order_id, type, actor) VALUES($1, $2, $3)"
+
+// actor of the changes made by the api itself
+const systemActor = "system"
+
+const insertOrder = "INSERT INTO delivery_order (distance, duration_seconds, estimated, mode, stops, idempotency_key, origin_address, destination_address, price_cents, currency, route, traffic_model, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, now()) ON CONFLICT (idempotency_key) DO NOTHING RETURNING " + orderColumns
+
+// args are the insertOrder arguments for o
+func (o NewOrder) args() []interface{} {
+	var key *string
+	if o.IdempotencyKey != "" {
+		key = &o.IdempotencyKey
+	}
+	return []interface{}{o.Distance, o.DurationSeconds, o.Estimated, o.Mode, o.Stops, key, o.OriginAddress, o.DestinationAddress, o.PriceCents, o.Currency, o.Route, o.TrafficModel}
+}
+
+// createOrder inserts o and its placed event in tx, or returns the
+// order placed with its idempotency key and false. A concurrent
+// insert of the key is waited for so the order is there to return
+func createOrder(ctx context.Context, tx *pgx.Tx, o NewOrder) (Order, bool, error) {
+	order, err := scanOrder(tx.QueryRowEx(ctx, insertOrder, nil, o.args()...))
+	if err == pgx.ErrNoRows {
+		order, err = scanOrder(tx.QueryRowEx(ctx, "SELECT "+orderColumns+" FROM delivery_order WHERE idempotency_key = $1", nil, o.IdempotencyKey))
+		return order, false, err
+	}
+	if err != nil {
+		return order, false, err
+	}
+	_, err = tx.ExecEx(ctx, insertEvent, nil, order.Id, EventPlaced, o.Actor)
+	return order, true, err
+}
+
+func (st *PgOrderStore) CreateOrder(ctx context.Context, o NewOrder) (Order, bool, error) {
+	defer st.timed("CreateOrder", time.Now())
+	var order Order
+	var created bool
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		var err error
+		order, created, err = createOrder(ctx, tx, o)
+		return err
+	})
+	return order, created, err
+}
+
+func (st *PgOrderStore) CreateOrders(ctx context.Context, orders []NewOrder) ([]Order, error) {
+	defer st.timed("CreateOrders", time.Now())
+	created := make([]Order, 0, len(orders))
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		for _, o := range orders {
+			// batches have no idempotency key so they're all created
+			order, _, err := createOrder(ctx, tx, o)
+			if err != nil {
+				return err
+			}
+			created = append(created, order)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (st *PgOrderStore) OrderByIdempotencyKey(ctx context.Context, key string, window time.Duration) (Order, error) {
+	defer st.timed("OrderByIdempotencyKey", time.Now())
+	_, err := st.db.ExecEx(
+		ctx,
+		"UPDATE delivery_order SET idempotency_key = NULL WHERE idempotency_key = $1 AND created_at < now() - $2 * interval '1 second'",
+		nil,
+		key, window.Seconds(),
+	)
+	if err != nil {
+		return Order{}, err
+	}
+	// only the select is retried, the update is a write
+	var o Order
+	err = st.read(ctx, func() error {
+		var err error
+		o, err = scanOrder(st.db.QueryRowEx(ctx, "SELECT "+orderColumns+" FROM delivery_order WHERE idempotency_key = $1", nil, key))
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		return o, ErrOrderNotFound
+	}
+	return o, err
+}
+
+func (st *PgOrderStore) GetOrder(ctx context.Context, id int64) (Order, error) {
+	defer st.timed("GetOrder", time.Now())
+	var o Order
+	err := st.read(ctx, func() error {
+		var err error
+		o, err = scanOrder(st.db.QueryRowEx(ctx, "SELECT "+orderColumns+" FROM delivery_order WHERE id = $1", nil, id))
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		return o, ErrOrderNotFound
+	}
+	return o, err
+}
+
+// transition moves an order from one status to another in a single
+// statement so concurrent requests can't both see it as from
+// set is extra assignments, like a timestamp, for the update, its
+// placeholders start at $5 for setArgs
+// event is what's recorded in the audit log along with the update
+func (st *PgOrderStore) transition(ctx context.Context, id, version int64, from, to, set, event, actor string, setArgs ...interface{}) (int64, error) {
+	var newVersion int64
+	args := append([]interface{}{id, to, from, version}, setArgs...)
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		err := tx.
+			QueryRowEx(ctx, "UPDATE delivery_order SET status = $2, version = version + 1, updated_at = now()"+set+" WHERE id = $1 AND status = $3 AND ($4 = 0 OR version = $4) RETURNING version", nil, args...).
+			Scan(&newVersion)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecEx(ctx, insertEvent, nil, id, event, actor)
+		return err
+	})
+	if err != pgx.ErrNoRows {
+		return newVersion, err
+	}
+	return st.unchanged(ctx, id, from)
+}
+
+// unchanged is why an update of order id in status from, at some
+// version, updated no row: either the order doesn't exist, it's in
+// some other status or it's been changed since version
+func (st *PgOrderStore) unchanged(ctx context.Context, id int64, from string) (int64, error) {
+	var status string
+	var current int64
+	err := st.db.
+		QueryRowEx(ctx, "SELECT status, version FROM delivery_order WHERE id = $1", nil, id).
+		Scan(&status, &current)
+	if err == pgx.ErrNoRows {
+		return 0, ErrOrderNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == from {
+		return current, ErrVersionMismatch
+	}
+	return current, &OrderStateError{Status: status, Version: current}
+}
+
+func (st *PgOrderStore) TakeOrder(ctx context.Context, id, version int64, driverId, actor string) (int64, error) {
+	defer st.timed("TakeOrder", time.Now())
+	return st.transition(ctx, id, version, StatusUnassign, StatusTaken, ", driver_id = $5", EventTaken, actor, driverId)
+}
+
+// CancelOrder is a soft cancel so the order stays in the history
+func (st *PgOrderStore) CancelOrder(ctx context.Context, id, version int64, actor string) (int64, error) {
+	defer st.timed("CancelOrder", time.Now())
+	return st.transition(ctx, id, version, StatusUnassign, StatusCancelled, ", cancelled_at = now()", EventCancelled, actor)
+}
+
+func (st *PgOrderStore) DeliverOrder(ctx context.Context, id, version int64, actor string) (int64, error) {
+	defer st.timed("DeliverOrder", time.Now())
+	return st.transition(ctx, id, version, StatusTaken, StatusDelivered, ", delivered_at = now()", EventDelivered, actor)
+}
+
+func (st *PgOrderStore) ChangeDestination(ctx context.Context, id, version int64, o NewOrder) (Order, error) {
+	defer st.timed("ChangeDestination", time.Now())
+	var order Order
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		var err error
+		order, err = scanOrder(tx.QueryRowEx(
+			ctx,
+			`UPDATE delivery_order SET distance = $4, duration_seconds = $5, estimated = $6, route = $7, destination_address = $8, price_cents = $9, currency = $10, traffic_model = $11, version = version + 1, updated_at = now()
+WHERE id = $1 AND status = $2 AND ($3 = 0 OR version = $3)
+RETURNING `+orderColumns,
+			nil,
+			id, StatusUnassign, version, o.Distance, o.DurationSeconds, o.Estimated, o.Route, o.DestinationAddress, o.PriceCents, o.Currency, o.TrafficModel,
+		))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecEx(ctx, insertEvent, nil, id, EventDestinationChanged, o.Actor)
+		return err
+	})
+	if err != pgx.ErrNoRows {
+		return order, err
+	}
+	_, err = st.unchanged(ctx, id, StatusUnassign)
+	return order, err
+}
+
+func (st *PgOrderStore) CancelOrders(ctx context.Context, f OrderFilter, actor string) ([]int64, error) {
+	defer st.timed("CancelOrders", time.Now())
+	// taken orders are in progress and can't be cancelled
+	f.Status = StatusUnassign
+	conditions, args := f.where()
+	args = append(args, StatusCancelled, EventCancelled, actor)
+	n := len(args)
+	query := fmt.Sprintf(`WITH cancelled AS (
+  UPDATE delivery_order SET status = $%d, cancelled_at = now(), version = version + 1, updated_at = now()
+  WHERE %s
+  RETURNING id
+)
+INSERT INTO order_events (order_id, type, actor) SELECT id, $%d, $%d FROM cancelled RETURNING order_id`, n-2, strings.Join(conditions, " AND "), n-1, n)
+
+	var ids []int64
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		rows, err := tx.QueryEx(ctx, query, nil, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			err := rows.Scan(&id)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (st *PgOrderStore) ExpireOrders(ctx context.Context, ttl time.Duration) (int64, error) {
+	defer st.timed("ExpireOrders", time.Now())
+	var expired int64
+	err := st.withTx(ctx, func(tx *pgx.Tx) error {
+		// another instance is on it, leave this round to it
+		var locked bool
+		err := tx.QueryRowEx(ctx, "SELECT pg_try_advisory_xact_lock($1)", nil, expiryLockId).Scan(&locked)
+		if err != nil || !locked {
+			return err
+		}
+		// one cancelled event per expired order
+		tag, err := tx.ExecEx(
+			ctx,
+			`WITH expired AS (
+  UPDATE delivery_order SET status = $1, cancelled_at = now(), version = version + 1, updated_at = now()
+  WHERE status = $2 AND created_at < now() - $3 * interval '1 second'
+  RETURNING id
+)
+INSERT INTO order_events (order_id, type, actor) SELECT id, $4, $5 FROM expired`,
+			nil,
+			StatusCancelled, StatusUnassign, ttl.Seconds(), EventCancelled, systemActor,
+		)
+		if err != nil {
+			return err
+		}
+		expired = tag.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+func (st *PgOrderStore) OrderHistory(ctx context.Context, id int64) ([]AuditEvent, error) {
+	defer st.timed("OrderHistory", time.Now())
+	var events []AuditEvent
+	err := st.read(ctx, func() error {
+		rows, err := st.db.QueryEx(ctx, "SELECT type, actor, created_at FROM order_events WHERE order_id = $1 ORDER BY id", nil, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		events = []AuditEvent{}
+		for rows.Next() {
+			var e AuditEvent
+			err := rows.Scan(&e.Type, &e.Actor, &e.CreatedAt)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return rows.Err()
+	})
+	if err != nil || len(events) > 0 {
+		return events, err
+	}
+
+	// orders placed before the audit log have no events either
+	var exists bool
+	err = st.read(ctx, func() error {
+		return st.db.QueryRowEx(ctx, "SELECT EXISTS (SELECT 1 FROM delivery_order WHERE id = $1)", nil, id).Scan(&exists)
+	})
+	if err == nil && !exists {
+		return nil, ErrOrderNotFound
+	}
+	return events, err
+}
+
+// where builds the filter conditions of f, paging aside
+func (f OrderFilter) where() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if f.DriverId != "" {
+		args = append(args, f.DriverId)
+		conditions = append(conditions, fmt.Sprintf("driver_id = $%d", len(args)))
+	}
+	if f.MinDistance != nil {
+		args = append(args, *f.MinDistance)
+		conditions = append(conditions, fmt.Sprintf("distance >= $%d", len(args)))
+	}
+	if f.MaxDistance != nil {
+		args = append(args, *f.MaxDistance)
+		conditions = append(conditions, fmt.Sprintf("distance <= $%d", len(args)))
+	}
+	if f.CreatedAfter != nil {
+		args = append(args, *f.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if f.CreatedBefore != nil {
+		args = append(args, *f.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+	return conditions, args
+}
+
+func (st *PgOrderStore) ListOrders(ctx context.Context, f OrderFilter) ([]Order, error) {
+	defer st.timed("ListOrders", time.Now())
+	conditions, args := f.where()
+	var order string
+	if f.After != nil {
+		// orders placed meanwhile sort before the cursor, so they
+		// can't shift the pages
+		args = append(args, f.After.CreatedAt, f.After.Id, f.Limit)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-2, len(args)-1))
+		order = fmt.Sprintf(" ORDER BY %s LIMIT $%d", orderSorts[SortCreatedDesc], len(args))
+	} else {
+		sort, ok := orderSorts[f.Sort]
+		if !ok {
+			sort = orderSorts[SortCreatedDesc]
+		}
+		args = append(args, f.Limit, f.Offset)
+		order = fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", sort, len(args)-1, len(args))
+	}
+	query := "SELECT " + orderColumns + " FROM delivery_order"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var orders []Order
+	err := st.read(ctx, func() error {
+		rows, err := st.db.QueryEx(ctx, query+order, nil, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		orders = []Order{}
+		for rows.Next() {
+			o, err := scanOrder(rows)
+			if err != nil {
+				return err
+			}
+			orders = append(orders, o)
+		}
+		// catch errors that stopped the iteration early
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (st *PgOrderStore) CountOrders(ctx context.Context, f OrderFilter) (int64, error) {
+	defer st.timed("CountOrders", time.Now())
+	conditions, args := f.where()
+	query := "SELECT count(*) FROM delivery_order"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	var total int64
+	err := st.read(ctx, func() error {
+		return st.db.QueryRowEx(ctx, query, nil, args...).Scan(&total)
+	})
+	return total, err
+}
+
+func (st *PgOrderStore) OrderStats(ctx context.Context, window time.Duration) (OrderStats, error) {
+	defer st.timed("OrderStats", time.Now())
+	var stats OrderStats
+	var unassigned, taken, delivered, cancelled int64
+	err := st.read(ctx, func() error {
+		return st.db.QueryRowEx(
+			ctx,
+			`SELECT
+  count(*),
+  count(*) FILTER (WHERE status = $2),
+  count(*) FILTER (WHERE status = $3),
+  count(*) FILTER (WHERE status = $4),
+  count(*) FILTER (WHERE status = $5),
+  coalesce(avg(distance), 0),
+  avg(duration_seconds)::float8
+FROM delivery_order
+WHERE created_at >= now() - $1 * interval '1 second'`,
+			nil,
+			window.Seconds(), StatusUnassign, StatusTaken, StatusDelivered, StatusCancelled,
+		).Scan(&stats.Total, &unassigned, &taken, &delivered, &cancelled, &stats.AverageDistance, &stats.AverageDuration)
+	})
+	stats.ByStatus = map[string]int64{
+		StatusUnassign:  unassigned,
+		StatusTaken:     taken,
+		StatusDelivered: delivered,
+		StatusCancelled: cancelled,
+	}
+	return stats, err
+}
+
+func (st *PgOrderStore) EachOrder(ctx context.Context, f OrderFilter, fn func(Order) error) error {
+	defer st.timed("EachOrder", time.Now())
+	conditions, args := f.where()
+	query := "SELECT " + orderColumns + " FROM delivery_order"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// like read, but once an order went to fn starting over would
+	// pass it again, so only a connection lost before that is retried
+	streamed := false
+	retryable := func(err error) bool {
+		return !streamed && isConnError(err)
+	}
+	return retry(ctx, 2, 0, retryable, func() error {
+		rows, err := st.db.QueryEx(ctx, query+" ORDER BY id", nil, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			o, err := scanOrder(rows)
+			if err != nil {
+				return err
+			}
+			streamed = true
+			err = fn(o)
+			if err != nil {
+				return err
+			}
+		}
+		// catch errors that stopped the iteration early
+		return rows.Err()
+	})
+}
+
+// Ping makes sure the db is actually usable
+func (st *PgOrderStore) Ping(ctx context.Context) error {
+	defer st.timed("Ping", time.Now())
+	var one int
+	return st.db.QueryRowEx(ctx, "SELECT 1", nil).Scan(&one)
+}
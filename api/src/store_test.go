@@ -0,0 +1,532 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"golang.org/x/net/context"
+
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore is a store on the db at DB_URI with the schema migrated
+// and every order deleted, so DB_URI must be a db the tests can wipe.
+// Tests that need a db are skipped when it isn't set
+func testStore(t *testing.T) *PgOrderStore {
+	uri := os.Getenv("DB_URI")
+	if uri == "" {
+		t.Skip("DB_URI is not set")
+	}
+	config, err := pgx.ParseConnectionString(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: config, MaxConnections: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = migrate(ctx, pool, "../migrations")
+	if err == nil {
+		_, err = pool.ExecEx(ctx, "TRUNCATE delivery_order, order_events RESTART IDENTITY", nil)
+	}
+	if err != nil {
+		pool.Close()
+		t.Fatal(err)
+	}
+	return NewPgOrderStore(pool)
+}
+
+// the pool hands out at most DB_MAX_CONNECTIONS connections, a query
+// past them waits DB_ACQUIRE_TIMEOUT and gives up
+func TestPoolMaxConnections(t *testing.T) {
+	uri := os.Getenv("DB_URI")
+	if uri == "" {
+		t.Skip("DB_URI is not set")
+	}
+	config, err := pgx.ParseConnectionString(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const max = 3
+	pool, err := connectDB(config, &Config{DBMaxConnections: max, DBAcquireTimeout: 100 * time.Millisecond, DBConnectDeadline: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var held []*pgx.Conn
+	for i := 0; i < max; i++ {
+		conn, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("acquiring connection %d of %d: %s", i+1, max, err)
+		}
+		held = append(held, conn)
+	}
+	if _, err := pool.Acquire(); err != pgx.ErrAcquireTimeout {
+		t.Errorf("got %v acquiring connection %d, want pgx.ErrAcquireTimeout", err, max+1)
+	}
+	if stat := pool.Stat(); stat.CurrentConnections != max {
+		t.Errorf("got %d connections open, want %d", stat.CurrentConnections, max)
+	}
+
+	pool.Release(held[0])
+	conn, err := pool.Acquire()
+	if err != nil {
+		t.Errorf("got %v once a connection was released, want it", err)
+	} else {
+		pool.Release(conn)
+	}
+	for _, conn := range held[1:] {
+		pool.Release(conn)
+	}
+}
+
+// placeTestOrder stores an unassigned order
+func placeTestOrder(t *testing.T, st *PgOrderStore) Order {
+	o, _, err := st.CreateOrder(context.Background(), testNewOrder(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// testNewOrder is a 1km order placed with idempotency key, none
+// when it's empty
+func testNewOrder(key string) NewOrder {
+	return NewOrder{
+		Distance:       1000,
+		Mode:           "driving",
+		IdempotencyKey: key,
+		PriceCents:     300,
+		Currency:       "USD",
+		Route:          []string{"1.000000,1.000000", "1.000000,1.010000"},
+		Actor:          "test",
+	}
+}
+
+// pages of orders sorted newest first don't skip or repeat orders,
+// not those placed at the same time nor when more are placed while
+// paging
+func TestListOrdersKeyset(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	// a batch is placed in one transaction so its orders have the
+	// same created_at
+	batch := make([]NewOrder, 5)
+	for i := range batch {
+		batch[i] = testNewOrder("")
+	}
+	placed, err := st.CreateOrders(ctx, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placeTestOrder(t, st)
+
+	var seen []int
+	f := OrderFilter{Sort: SortCreatedDesc, Limit: 2}
+	for page := 0; ; page++ {
+		orders, err := st.ListOrders(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range orders {
+			seen = append(seen, o.Id)
+		}
+		if int64(len(orders)) < f.Limit {
+			break
+		}
+		last := orders[len(orders)-1]
+		f.After = &OrderCursor{CreatedAt: last.Created_at, Id: int64(last.Id)}
+		if page == 0 {
+			// newer than the cursor, they're not on later pages
+			placeTestOrder(t, st)
+		}
+	}
+
+	// the order placed alone first, then the batch newest id first
+	want := []int{placed[len(placed)-1].Id + 1}
+	for i := len(placed) - 1; i >= 0; i-- {
+		want = append(want, placed[i].Id)
+	}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("got orders %v across the pages, want %v", seen, want)
+	}
+}
+
+// both bounds of the distance range are inclusive
+func TestListOrdersDistanceBounds(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	for _, d := range []int{999, 1000, 1001} {
+		o := testNewOrder("")
+		o.Distance = d
+		if _, _, err := st.CreateOrder(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bound := func(d float64) *float64 { return &d }
+	tests := []struct {
+		min, max *float64
+		want     string
+	}{
+		{bound(1000), nil, "[1001 1000]"},
+		{nil, bound(1000), "[1000 999]"},
+		{bound(1000), bound(1000), "[1000]"},
+		{bound(999.5), bound(1000.5), "[1000]"},
+		{bound(1002), nil, "[]"},
+	}
+	for _, test := range tests {
+		f := OrderFilter{Sort: SortDistanceDesc, Limit: 10, MinDistance: test.min, MaxDistance: test.max}
+		orders, err := st.ListOrders(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for _, o := range orders {
+			got = append(got, o.Distance)
+		}
+		count, err := st.CountOrders(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != test.want || count != int64(len(got)) {
+			t.Errorf("%s to %s: got %v counting %d, want %s", floatString(test.min), floatString(test.max), got, count, test.want)
+		}
+	}
+}
+
+// the created range is inclusive and either end can be left open
+func TestListOrdersCreatedBounds(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	var created []time.Time
+	for i := 0; i < 3; i++ {
+		created = append(created, placeTestOrder(t, st).Created_at)
+	}
+	tests := []struct {
+		after, before *time.Time
+		want          string
+	}{
+		{&created[1], nil, "[3 2]"},
+		{nil, &created[1], "[2 1]"},
+		{&created[1], &created[1], "[2]"},
+		{&created[0], &created[2], "[3 2 1]"},
+		{nil, nil, "[3 2 1]"},
+	}
+	for _, test := range tests {
+		f := OrderFilter{Sort: SortCreatedDesc, Limit: 10, CreatedAfter: test.after, CreatedBefore: test.before}
+		orders, err := st.ListOrders(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, o := range orders {
+			got = append(got, o.Id)
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%s to %s: got orders %v, want %s", timeString(test.after), timeString(test.before), got, test.want)
+		}
+	}
+}
+
+// requests racing with the same key all get the one order placed
+func TestCreateOrderSameKeyConcurrently(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	const requests = 10
+	type result struct {
+		order   Order
+		created bool
+		err     error
+	}
+	results := make(chan result, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o, created, err := st.CreateOrder(ctx, testNewOrder("same"))
+			results <- result{o, created, err}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	ids := map[int]bool{}
+	created := 0
+	for r := range results {
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		ids[r.order.Id] = true
+		if r.created {
+			created++
+		}
+	}
+	if len(ids) != 1 || created != 1 {
+		t.Errorf("got orders %v with %d created, want one order created once", ids, created)
+	}
+	var orders, events int
+	err := st.db.QueryRowEx(ctx, "SELECT (SELECT count(*) FROM delivery_order), (SELECT count(*) FROM order_events)", nil).Scan(&orders, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders != 1 || events != 1 {
+		t.Errorf("got %d orders and %d events stored, want 1 of each", orders, events)
+	}
+}
+
+// orders that tie on every sort key come back in id order, the same
+// on every page and without repeats, whatever the sort
+func TestListOrdersTies(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	// one transaction so they share created_at, and one distance
+	batch := make([]NewOrder, 7)
+	for i := range batch {
+		batch[i] = testNewOrder("")
+	}
+	placed, err := st.CreateOrders(ctx, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ascending, descending []int
+	for i := range placed {
+		ascending = append(ascending, placed[i].Id)
+		descending = append(descending, placed[len(placed)-1-i].Id)
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{SortCreatedDesc, descending},
+		{SortCreatedAsc, ascending},
+		{SortDistanceDesc, descending},
+		{SortDistanceAsc, ascending},
+	}
+	for _, test := range tests {
+		for run := 0; run < 2; run++ {
+			var got []int
+			for offset := int64(0); offset < int64(len(placed)); offset += 3 {
+				orders, err := st.ListOrders(ctx, OrderFilter{Sort: test.sort, Limit: 3, Offset: offset})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, o := range orders {
+					got = append(got, o.Id)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("%s, run %d: got orders %v across the pages, want %v", test.sort, run+1, got, test.want)
+			}
+		}
+	}
+}
+
+func TestOrderByIdempotencyKeyExpires(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	o, _, err := st.CreateOrder(ctx, testNewOrder("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.OrderByIdempotencyKey(ctx, "key", time.Hour)
+	if err != nil || got.Id != o.Id {
+		t.Fatalf("got order %d, %v, want order %d", got.Id, err, o.Id)
+	}
+	// older than the window, the key is released
+	_, err = st.OrderByIdempotencyKey(ctx, "key", 0)
+	if err != ErrOrderNotFound {
+		t.Errorf("got %v for an expired key, want ErrOrderNotFound", err)
+	}
+	again, created, err := st.CreateOrder(ctx, testNewOrder("key"))
+	if err != nil || !created || again.Id == o.Id {
+		t.Errorf("got order %d created %t, %v, want a new order for the released key", again.Id, created, err)
+	}
+}
+
+// the driver of a take is kept, and orders can be listed by it
+func TestTakeOrderKeepsDriverId(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	o := placeTestOrder(t, st)
+	other := placeTestOrder(t, st)
+	if _, err := st.TakeOrder(ctx, int64(o.Id), 0, "driver-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.TakeOrder(ctx, int64(other.Id), 0, "driver-2", "test"); err != nil {
+		t.Fatal(err)
+	}
+	taken, err := st.GetOrder(ctx, int64(o.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken.Driver_id == nil || *taken.Driver_id != "driver-1" {
+		t.Errorf("got driver %v, want driver-1", taken.Driver_id)
+	}
+	orders, err := st.ListOrders(ctx, OrderFilter{DriverId: "driver-1", Sort: SortCreatedDesc, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].Id != o.Id {
+		t.Errorf("got %d orders for driver-1, want order %d only", len(orders), o.Id)
+	}
+}
+
+// updated_at starts at created_at and moves with every change, but
+// not with a change that was refused
+func TestUpdatedAt(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	o := placeTestOrder(t, st)
+	if !o.Updated_at.Equal(o.Created_at) {
+		t.Errorf("got updated_at %s for a new order, want created_at %s", o.Updated_at, o.Created_at)
+	}
+	if _, err := st.TakeOrder(ctx, int64(o.Id), 0, "driver-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	taken, err := st.GetOrder(ctx, int64(o.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !taken.Updated_at.After(o.Created_at) {
+		t.Errorf("got updated_at %s after a take, want it later than %s", taken.Updated_at, o.Created_at)
+	}
+
+	if _, err := st.TakeOrder(ctx, int64(o.Id), 0, "driver-2", "test"); err == nil {
+		t.Fatal("took an order twice")
+	}
+	again, err := st.GetOrder(ctx, int64(o.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Updated_at.Equal(taken.Updated_at) {
+		t.Errorf("got updated_at %s after a refused take, want %s still", again.Updated_at, taken.Updated_at)
+	}
+}
+
+// stats only count orders placed within the window
+func TestOrderStats(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	seconds := func(s int64) *int64 { return &s }
+	orders := []struct {
+		distance int
+		duration *int64
+	}{
+		{1000, seconds(60)},
+		{2000, nil},
+		{4000, seconds(120)},
+		// placed too long ago to count
+		{100000, seconds(6000)},
+	}
+	var ids []int64
+	for _, o := range orders {
+		n := testNewOrder("")
+		n.Distance, n.DurationSeconds = o.distance, o.duration
+		placed, _, err := st.CreateOrder(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int64(placed.Id))
+	}
+	_, err := st.db.ExecEx(ctx, "UPDATE delivery_order SET created_at = now() - interval '2 hours' WHERE id = $1", nil, ids[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.TakeOrder(ctx, ids[0], 0, "driver-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CancelOrder(ctx, ids[1], 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := st.OrderStats(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantByStatus := map[string]int64{StatusUnassign: 1, StatusTaken: 1, StatusDelivered: 0, StatusCancelled: 1}
+	if stats.Total != 3 || fmt.Sprint(stats.ByStatus) != fmt.Sprint(wantByStatus) {
+		t.Errorf("got %d orders by status %v, want 3 by %v", stats.Total, stats.ByStatus, wantByStatus)
+	}
+	// the order without a duration isn't in its average
+	if int(stats.AverageDistance) != 2333 || stats.AverageDuration == nil || *stats.AverageDuration != 90 {
+		t.Errorf("got averages of %v m and %v s, want 2333.33 m and 90 s", stats.AverageDistance, stats.AverageDuration)
+	}
+
+	stats, err = st.OrderStats(ctx, 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 4 {
+		t.Errorf("got %d orders over 3h, want 4", stats.Total)
+	}
+}
+
+// nothing a failed transaction wrote is kept, like an order whose
+// event couldn't be inserted
+func TestWithTxRollsBack(t *testing.T) {
+	st := testStore(t)
+	defer st.db.Close()
+	ctx := context.Background()
+
+	failEvent := func(tx *pgx.Tx) error {
+		if _, _, err := createOrder(ctx, tx, testNewOrder("")); err != nil {
+			return err
+		}
+		_, err := tx.ExecEx(ctx, "INSERT INTO order_events (order_id, type, actor) VALUES (0, NULL, NULL)", nil)
+		return err
+	}
+	if err := st.withTx(ctx, failEvent); err == nil {
+		t.Fatal("got no error inserting a bad event")
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("got panic %v, want it passed on", p)
+			}
+		}()
+		st.withTx(ctx, func(tx *pgx.Tx) error {
+			if _, _, err := createOrder(ctx, tx, testNewOrder("")); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	var orders, events int
+	err := st.db.QueryRowEx(ctx, "SELECT (SELECT count(*) FROM delivery_order), (SELECT count(*) FROM order_events)", nil).Scan(&orders, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders != 0 || events != 0 {
+		t.Errorf("got %d orders and %d events after rolled back transactions, want none", orders, events)
+	}
+	// the connections went back to the pool
+	if stat := st.db.Stat(); stat.CurrentConnections != stat.AvailableConnections {
+		t.Errorf("got %d of %d connections available, want all of them", stat.AvailableConnections, stat.CurrentConnections)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// FieldError is what's wrong with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors are all the problems found in a request body so
+// clients can fix them in one go
+type ValidationErrors []FieldError
+
+func (errs *ValidationErrors) add(field, message string) {
+	*errs = append(*errs, FieldError{Field: field, Message: message})
+}
+
+// err is errs as an error, nil when there are none
+func (errs ValidationErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// under nests the fields of errs in parent, like orders[0].origin
+func (errs ValidationErrors) under(parent string) ValidationErrors {
+	nested := make(ValidationErrors, len(errs))
+	for i, e := range errs {
+		nested[i] = FieldError{Field: parent + "." + e.Field, Message: e.Message}
+	}
+	return nested
+}
+
+// unknownFields lists the keys of the JSON object in blob that aren't
+// in known, which encoding/json would otherwise quietly drop. Keys
+// are matched exactly so a miscased one is reported too
+// blob is expected to have unmarshalled already
+func unknownFields(blob []byte, known ...string) ValidationErrors {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(blob, &fields) != nil {
+		return nil
+	}
+	var errs ValidationErrors
+	for field := range fields {
+		if !contains(known, field) {
+			errs.add(field, "is not a known field")
+		}
+	}
+	// map order is random, keep responses stable
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Field + " " + e.Message
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"golang.org/x/net/context"
+
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook POSTs order events to a URL in the background. The body is
+// signed with an HMAC-SHA256 of the shared secret, hex encoded in the
+// X-Signature-SHA256 header, so receivers can check it came from us.
+type Webhook struct {
+	url      string
+	secret   []byte
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+}
+
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		url:      url,
+		secret:   []byte(secret),
+		client:   &http.Client{Timeout: timeout},
+		attempts: 3,
+		backoff:  500 * time.Millisecond,
+	}
+}
+
+// webhookStatusError is a response that isn't a 2xx
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.status)
+}
+
+// receivers that are down or overloaded are retried, ones that
+// reject the request aren't
+func isTransientWebhookError(err error) bool {
+	if statusErr, ok := err.(*webhookStatusError); ok {
+		return statusErr.status >= 500 || statusErr.status == 429
+	}
+	return true
+}
+
+// Send delivers event without blocking the caller, failures are logged
+func (wh *Webhook) Send(event OrderEvent) {
+	blob, err := json.Marshal(&event)
+	if err != nil {
+		logger.Error("Error in marshalling webhook event", Fields{"error": err})
+		return
+	}
+	mac := hmac.New(sha256.New, wh.secret)
+	mac.Write(blob)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	wh.wg.Add(1)
+	go func() {
+		defer wh.wg.Done()
+		err := retry(context.Background(), wh.attempts, wh.backoff, isTransientWebhookError, func() error {
+			return wh.post(blob, signature)
+		})
+		if err != nil {
+			logger.Error("Error in sending webhook", Fields{"error": err, "order_id": event.OrderId, "status": event.Status})
+		}
+	}()
+}
+
+func (wh *Webhook) post(blob []byte, signature string) error {
+	req, err := http.NewRequest("POST", wh.url, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-SHA256", signature)
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{resp.StatusCode}
+	}
+	return nil
+}
+
+// Wait blocks until the events being sent are delivered or given up on
+func (wh *Webhook) Wait() {
+	wh.wg.Wait()
+}